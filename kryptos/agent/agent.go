@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"skulpture/kryptos/kryptos"
+	"sync"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+var (
+	ErrRunning          = errors.New("kryptos agent is already running")
+	ErrDatabaseMismatch = errors.New("agent reads a different database")
+)
+
+// Agent keeps decrypted values in memory for Ttl and answers other kryptos invocations over a unix socket.
+// It locks, forgetting every value, after Idle without a request or when asked to
+type Agent struct {
+	Db       *sql.DB
+	Driver   string
+	Database string
+	Keyring  kryptos.Keyring
+	Ttl      time.Duration
+	Idle     time.Duration
+
+	mutex    sync.Mutex
+	cache    map[cacheKey]cached
+	lastUsed time.Time
+	locked   chan struct{}
+	lockOnce sync.Once
+}
+
+type cacheKey struct {
+	project string
+	stage   string
+}
+
+type cached struct {
+	envs     *orderedmap.OrderedMap[string, string]
+	sources  map[string]string
+	loadedAt time.Time
+}
+
+// Env carries the project the value resolves from so clients check grants against it
+type Env struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Project string `json:"project"`
+}
+
+type EnvsResponse struct {
+	Envs []Env `json:"envs"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Listen creates the socket only the current user can connect to, a socket left by an agent that exited is replaced
+func Listen(socket string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(socket), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		conn, err := net.DialTimeout("unix", socket, time.Second)
+		if err == nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("%w: %s", ErrRunning, socket)
+		}
+
+		err = os.Remove(socket)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func (agent *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/envs", agent.envs)
+	mux.HandleFunc("POST /v1/forget", agent.forget)
+	mux.HandleFunc("POST /v1/lock", agent.lock)
+
+	return mux
+}
+
+// Serve answers requests until the agent is locked, it is idle or ctx is done
+func (agent *Agent) Serve(ctx context.Context, listener net.Listener) error {
+	agent.mutex.Lock()
+	agent.cache = map[cacheKey]cached{}
+	agent.lastUsed = time.Now()
+	if agent.locked == nil {
+		agent.locked = make(chan struct{})
+	}
+	agent.mutex.Unlock()
+
+	httpServer := http.Server{
+		Handler: agent.Handler(),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	var idle <-chan time.Time
+	if agent.Idle > 0 {
+		ticker := time.NewTicker(min(agent.Idle, time.Minute))
+		defer ticker.Stop()
+
+		idle = ticker.C
+	}
+
+	for isServing := true; isServing; {
+		select {
+		case err := <-served:
+			agent.Lock()
+
+			return err
+		case <-ctx.Done():
+			isServing = false
+		case <-agent.locked:
+			isServing = false
+		case <-idle:
+			agent.mutex.Lock()
+			isServing = time.Since(agent.lastUsed) < agent.Idle
+			agent.mutex.Unlock()
+		}
+	}
+
+	agent.Lock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		return err
+	}
+
+	err = <-served
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Lock forgets every value and the keyring, Serve returns once the agent is locked
+func (agent *Agent) Lock() {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	agent.cache = map[cacheKey]cached{}
+	agent.Keyring = kryptos.Keyring{}
+
+	if agent.locked == nil {
+		agent.locked = make(chan struct{})
+	}
+
+	agent.lockOnce.Do(func() {
+		close(agent.locked)
+	})
+}
+
+// load returns the cached values of a project and stage with the project each resolves from,
+// they are read again once older than Ttl
+func (agent *Agent) load(ctx context.Context, project string, stage string) (*orderedmap.OrderedMap[string, string], map[string]string, error) {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	agent.lastUsed = time.Now()
+
+	key := cacheKey{project, stage}
+	entry, ok := agent.cache[key]
+	if ok && time.Since(entry.loadedAt) < agent.Ttl {
+		return entry.envs, entry.sources, nil
+	}
+
+	store := kryptos.NewStore(
+		kryptos.WithDb(agent.Db),
+		kryptos.WithDriver(agent.Driver),
+		kryptos.WithProject(project),
+		kryptos.WithStage(stage),
+		kryptos.WithKeyring(agent.Keyring),
+	)
+
+	envs, sources, err := store.ListSourcesAsOf(ctx, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	agent.cache[key] = cached{
+		envs:     envs,
+		sources:  sources,
+		loadedAt: time.Now(),
+	}
+
+	if isDebugEnabled, _ := ctx.Value(kryptos.ContextKeyDebug).(bool); isDebugEnabled {
+		slog.InfoContext(ctx, "agent", "project", project, "stage", stage, "loaded", envs.Len())
+	}
+
+	return envs, sources, nil
+}
+
+func (agent *Agent) envs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("database") != agent.Database {
+		writeJson(w, http.StatusConflict, ErrorResponse{Error: ErrDatabaseMismatch.Error()})
+
+		return
+	}
+
+	envs, sources, err := agent.load(r.Context(), query.Get("project"), query.Get("stage"))
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+
+		return
+	}
+
+	response := EnvsResponse{
+		Envs: []Env{},
+	}
+	for key, value := range envs.Iterator() {
+		response.Envs = append(response.Envs, Env{Key: key, Value: value, Project: sources[key]})
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (agent *Agent) forget(w http.ResponseWriter, r *http.Request) {
+	agent.mutex.Lock()
+	agent.cache = map[cacheKey]cached{}
+	agent.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (agent *Agent) lock(w http.ResponseWriter, r *http.Request) {
+	agent.Lock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"skulpture/kryptos/agent"
+	"skulpture/kryptos/kryptos"
+	"syscall"
+	"time"
+)
+
+type Agent struct {
+	Db     *sql.DB
+	Socket string
+	Ttl    time.Duration
+	Idle   time.Duration
+	View   io.Writer
+}
+
+// Serves decrypted values to other invocations until locked, idle or interrupted
+func (command *Agent) Execute(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := agent.Listen(command.Socket)
+	if err != nil {
+		return err
+	}
+
+	cache := agent.Agent{
+		Db:       command.Db,
+		Driver:   kryptos.DB_DRIVER.Value(),
+		Database: kryptos.Database(),
+		Keyring:  kryptos.CurrentKeyring(),
+		Ttl:      command.Ttl,
+		Idle:     command.Idle,
+	}
+
+	_, err = fmt.Fprintf(command.View, "Agent unlocked on %s\n", command.Socket)
+	if err != nil {
+		return err
+	}
+
+	err = cache.Serve(ctx, listener)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(command.View, "Agent locked")
+
+	return err
+}
+
+type AgentLock struct {
+	View io.Writer
+}
+
+func (command *AgentLock) Execute(ctx context.Context) error {
+	err := kryptos.LockAgent(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(command.View, "Agent locked")
+
+	return err
+}
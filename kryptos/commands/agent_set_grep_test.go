@@ -0,0 +1,175 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentSetGrep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		socket := filepath.Join(t.TempDir(), "agent.sock")
+		kryptos.SetAgentSocket(socket)
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		setEnvCommand := commands.SetEnv{
+			Db:    db,
+			Key:   "AGENT1",
+			Value: "AGENT1",
+		}
+
+		err := setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agentCommand := commands.Agent{
+			Db:     db,
+			Socket: socket,
+			Ttl:    time.Hour,
+			Idle:   time.Hour,
+			View:   &bytes.Buffer{},
+		}
+
+		served := make(chan error, 1)
+		go func() {
+			served <- agentCommand.Execute(ctx)
+		}()
+
+		assert.Eventually(t, kryptos.IsAgentRunning, time.Second, 10*time.Millisecond)
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// written behind the agent's back, the cached value is still served
+		err = kryptos.NewStore(kryptos.WithDb(db), kryptos.WithProject("test"), kryptos.WithKeyring(kryptos.CurrentKeyring())).Set(ctx, "AGENT1", "AGENT1.1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "AGENT1", "AGENT1")
+
+		// writes through kryptos make the agent forget
+		setEnvCommand.Value = "AGENT1.2"
+
+		err = setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "AGENT1", "AGENT1.2")
+
+		agentLockCommand := commands.AgentLock{
+			View: &bytes.Buffer{},
+		}
+
+		err = agentLockCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err = <-served:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("agent did not lock")
+		}
+
+		assert.False(t, kryptos.IsAgentRunning())
+
+		err = agentLockCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrAgentNotRunning)
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "AGENT1", "AGENT1.2")
+	}
+}
+
+func TestAgentIdle(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		socket := filepath.Join(t.TempDir(), "agent.sock")
+		kryptos.SetAgentSocket(socket)
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		agentCommand := commands.Agent{
+			Db:     db,
+			Socket: socket,
+			Ttl:    time.Hour,
+			Idle:   100 * time.Millisecond,
+			View:   &bytes.Buffer{},
+		}
+
+		served := make(chan error, 1)
+		go func() {
+			served <- agentCommand.Execute(ctx)
+		}()
+
+		select {
+		case err = <-served:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("agent did not lock when idle")
+		}
+
+		assert.False(t, kryptos.IsAgentRunning())
+	}
+}
+
+func assertGrep(t *testing.T, ctx context.Context, key string, expected string) {
+	out := bytes.Buffer{}
+	grepCommand := commands.Grep{
+		Key:  key,
+		View: &out,
+	}
+
+	err := grepCommand.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, strings.TrimSpace(out.String()))
+}
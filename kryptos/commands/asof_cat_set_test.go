@@ -0,0 +1,214 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatAsOfMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		before := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "ASOF1",
+				Value:    "ASOF1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "ASOF2",
+				Value:    "ASOF2",
+				IsGlobal: false,
+			},
+		}
+
+		after := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "ASOF1",
+				Value:    "ASOF1.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "ASOF2",
+				Value:    "ASOF2.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "ASOF3",
+				Value:    "ASOF3",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range before {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		asOf := time.Now()
+		time.Sleep(2 * time.Millisecond)
+
+		for _, command := range after {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = kryptos.GetEnvsAsOf(ctx, db, asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		catCommand := commands.Cat{
+			View: &out,
+		}
+
+		err = catCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		RESULT := out.String()
+
+		for _, command := range before {
+			assert.Contains(t, RESULT, fmt.Sprintf("%s=%s\n", command.Key, command.Value))
+		}
+
+		for _, command := range after {
+			assert.NotContains(t, RESULT, fmt.Sprintf("%s=%s\n", command.Key, command.Value))
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out = bytes.Buffer{}
+		err = catCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		RESULT = out.String()
+
+		for _, command := range after {
+			assert.Contains(t, RESULT, fmt.Sprintf("%s=%s\n", command.Key, command.Value))
+		}
+	}
+}
+
+func TestCatAsOfRmMv(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commandsBefore := []commands.SetEnv{
+			{Db: db, Key: "ASOF_RM", Value: "ASOF_RM"},
+			{Db: db, Key: "ASOF_RM", Value: "ASOF_RM.1"},
+			{Db: db, Key: "ASOF_MV", Value: "ASOF_MV"},
+		}
+
+		for _, command := range commandsBefore {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		beforeRm := time.Now()
+		time.Sleep(2 * time.Millisecond)
+
+		rmCommand := commands.Rm{Db: db, Key: "ASOF_RM"}
+		err = rmCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		afterRm := time.Now()
+		time.Sleep(2 * time.Millisecond)
+
+		// the version deprecated by the second set is left behind by rm, it was never current after the rm
+		err = kryptos.GetEnvsAsOf(ctx, db, afterRm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "ASOF_RM", "")
+		assertGrep(t, ctx, "ASOF_MV", "ASOF_MV")
+
+		// the version current at the time was deleted
+		err = kryptos.GetEnvsAsOf(ctx, db, beforeRm)
+		assert.ErrorIs(t, err, kryptos.ErrHistoryUnavailable)
+
+		mvCommand := commands.Mv{Db: db, Previous: "ASOF_MV", Next: "ASOF_MV_NEXT"}
+		err = mvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		afterMv := time.Now()
+
+		err = kryptos.GetEnvsAsOf(ctx, db, afterRm)
+		assert.ErrorIs(t, err, kryptos.ErrHistoryUnavailable)
+
+		err = kryptos.GetEnvsAsOf(ctx, db, afterMv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "ASOF_RM", "")
+		assertGrep(t, ctx, "ASOF_MV", "")
+		assertGrep(t, ctx, "ASOF_MV_NEXT", "ASOF_MV")
+	}
+}
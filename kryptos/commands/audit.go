@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+	"text/tabwriter"
+	"time"
+)
+
+type Audit struct {
+	Db     *sql.DB
+	Since  time.Time
+	Key    string
+	Actor  string
+	IsJson bool
+	View   io.Writer
+}
+
+// Lists the audit log of the project oldest first, a project of * lists every project
+func (command *Audit) Execute(ctx context.Context) error {
+	entries, err := kryptos.AuditLog(ctx, command.Db, kryptos.AuditFilter{
+		Project: kryptos.Project(),
+		Since:   command.Since,
+		Key:     command.Key,
+		Actor:   command.Actor,
+	})
+	if err != nil {
+		return err
+	}
+
+	if command.IsJson {
+		return json.NewEncoder(command.View).Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
+
+	fmt.Fprintln(w, "Time\tActor\tHost\tOp\tProject\tStage\tKey\tOutcome\tReason")
+
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.CreatedAt.Format(time.RFC3339), entry.Actor, entry.Host, entry.Op, entry.Project, entry.Stage, entry.Key, entry.Outcome, entry.Reason)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,163 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditSetGrep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		reasonCtx := context.WithValue(ctx, kryptos.ContextKeyReason, "incident 42")
+
+		operations := []struct {
+			op  string
+			key string
+			err error
+		}{
+			{"set", "AUDIT1", nil},
+			{"grep", "AUDIT1", nil},
+			{"cat", "", nil},
+			{"grep", "OTHER1", nil},
+			{"rm", "AUDIT1", kryptos.ErrPermissionDenied},
+			{"rollback", "AUDIT1", kryptos.ErrVersionNotFound},
+		}
+
+		for _, operation := range operations {
+			err := kryptos.Audit(reasonCtx, db, operation.op, operation.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = kryptos.AuditResult(reasonCtx, db, operation.op, operation.key, operation.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := bytes.Buffer{}
+		auditCommand := commands.Audit{
+			Db:     db,
+			Key:    "AUDIT*",
+			IsJson: true,
+			View:   &out,
+		}
+
+		err := auditCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries := []kryptos.AuditEntry{}
+		err = json.Unmarshal(out.Bytes(), &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.Len(t, entries, 8) {
+			assert.Equal(t, "set", entries[0].Op)
+			assert.Equal(t, kryptos.AUDIT_OUTCOME_STARTED, entries[0].Outcome)
+			assert.Equal(t, kryptos.AUDIT_OUTCOME_OK, entries[1].Outcome)
+			assert.Equal(t, "grep", entries[2].Op)
+			assert.Equal(t, "AUDIT1", entries[2].Key)
+			assert.Equal(t, "test", entries[2].Project)
+			assert.Equal(t, "test", entries[2].Actor)
+			assert.Equal(t, "incident 42", entries[2].Reason)
+			assert.NotEmpty(t, entries[2].Host)
+			assert.Equal(t, "rm", entries[4].Op)
+			assert.Equal(t, kryptos.AUDIT_OUTCOME_DENIED, entries[5].Outcome)
+			assert.Equal(t, kryptos.ErrPermissionDenied.Error(), entries[5].Error)
+			assert.Equal(t, kryptos.AUDIT_OUTCOME_FAILED, entries[7].Outcome)
+			assert.Equal(t, kryptos.ErrVersionNotFound.Error(), entries[7].Error)
+		}
+
+		filters := []commands.Audit{
+			{Db: db, Key: "*", Actor: "nobody"},
+			{Db: db, Key: "*", Since: time.Now().Add(time.Hour)},
+		}
+
+		for _, filter := range filters {
+			out.Reset()
+			filter.IsJson = true
+			filter.View = &out
+
+			err = filter.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.JSONEq(t, "[]", out.String())
+		}
+
+		// entries cannot be changed or removed
+		_, err = db.ExecContext(ctx, "UPDATE audit_log SET reason = '';")
+		assert.Error(t, err)
+
+		_, err = db.ExecContext(ctx, "DELETE FROM audit_log;")
+		assert.Error(t, err)
+
+		// with a principal the entries are recorded against it and reading them needs admin
+		kryptos.SetPrincipal("alice")
+		defer kryptos.SetPrincipal("")
+
+		err = kryptos.Audit(ctx, db, "cat", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = auditCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrPermissionDenied)
+
+		kryptos.SetPrincipal("")
+
+		out.Reset()
+		auditCommand = commands.Audit{
+			Db:     db,
+			Key:    "*",
+			Actor:  "alice",
+			IsJson: true,
+			View:   &out,
+		}
+
+		err = auditCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = []kryptos.AuditEntry{}
+		err = json.Unmarshal(out.Bytes(), &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "cat", entries[0].Op)
+			assert.Empty(t, entries[0].Reason)
+		}
+
+		// a failed audit write is returned
+		_, err = db.ExecContext(ctx, "DROP TABLE audit_log;")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.Audit(ctx, db, "grep", "AUDIT1")
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,172 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/elliotchance/orderedmap/v2"
+	"github.com/joho/godotenv"
+)
+
+var ErrDifferent = errors.New("environments differ")
+
+type Diff struct {
+	Db         *sql.DB
+	From       string
+	To         string
+	File       string
+	ShowValues bool
+	IsJson     bool
+	View       io.Writer
+}
+
+type envDiff struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Compares two projects, or the current project against a dotenv file when File is set.
+// Prints the differences, then returns ErrDifferent so CI gates can fail on drift
+func (command *Diff) Execute(ctx context.Context) error {
+	fromName := command.From
+	toName := command.To
+
+	var from *orderedmap.OrderedMap[string, string]
+	var to *orderedmap.OrderedMap[string, string]
+	var err error
+	if command.File != "" {
+		fromName = kryptos.Project()
+		toName = command.File
+
+		from = kryptos.ENVS
+
+		to, err = readEnvFile(command.File)
+		if err != nil {
+			return err
+		}
+	} else {
+		fromProject, fromStage := splitScope(command.From)
+		from, err = kryptos.ResolveEnvs(ctx, command.Db, fromProject, fromStage)
+		if err != nil {
+			return err
+		}
+
+		toProject, toStage := splitScope(command.To)
+		to, err = kryptos.ResolveEnvs(ctx, command.Db, toProject, toStage)
+		if err != nil {
+			return err
+		}
+	}
+
+	diffs := compareEnvs(from, to, command.ShowValues)
+
+	if command.IsJson {
+		err = json.NewEncoder(command.View).Encode(diffs)
+		if err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
+
+		fmt.Fprintf(w, "Key\tStatus\t%s\t%s\n", fromName, toName)
+
+		for _, diff := range diffs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", diff.Key, diff.Status, diff.From, diff.To)
+		}
+
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(diffs) > 0 {
+		return ErrDifferent
+	}
+
+	return nil
+}
+
+// splitScope reads project:stage, a project without a stage uses the current stage
+func splitScope(spec string) (string, string) {
+	project, stage, ok := strings.Cut(spec, ":")
+	if !ok {
+		return spec, kryptos.Stage()
+	}
+
+	return project, stage
+}
+
+// readEnvFile parses a dotenv file the same way ENV_FILE_PATH is imported
+func readEnvFile(path string) (*orderedmap.OrderedMap[string, string], error) {
+	loaded, err := godotenv.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	envs := orderedmap.NewOrderedMap[string, string]()
+	for key, value := range loaded {
+		envs.Set(key, value)
+	}
+
+	return envs, nil
+}
+
+func compareEnvs(from *orderedmap.OrderedMap[string, string], to *orderedmap.OrderedMap[string, string], showValues bool) []envDiff {
+	keys := from.Keys()
+	for _, key := range to.Keys() {
+		if _, ok := from.Get(key); !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.SortStableFunc(keys, func(a string, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+
+	display := kryptos.Fingerprint
+	if showValues {
+		display = func(value string) string {
+			return value
+		}
+	}
+
+	diffs := []envDiff{}
+	for _, key := range keys {
+		fromValue, inFrom := from.Get(key)
+		toValue, inTo := to.Get(key)
+
+		if inFrom && !inTo {
+			diffs = append(diffs, envDiff{
+				Key:    key,
+				Status: "removed",
+				From:   display(fromValue),
+			})
+		} else if !inFrom && inTo {
+			diffs = append(diffs, envDiff{
+				Key:    key,
+				Status: "added",
+				To:     display(toValue),
+			})
+		} else if fromValue != toValue {
+			diffs = append(diffs, envDiff{
+				Key:    key,
+				Status: "changed",
+				From:   display(fromValue),
+				To:     display(toValue),
+			})
+		}
+	}
+
+	return diffs
+}
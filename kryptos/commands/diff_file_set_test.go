@@ -0,0 +1,136 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffFileMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "DIFFFILE1",
+				Value:    "DIFFFILE1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "DIFFFILE2",
+				Value:    "DIFFFILE2",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "DIFFFILE3",
+				Value:    "DIFFFILE3 with spaces",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tmp, err := os.CreateTemp("./", "secrets-diff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		_, err = tmp.WriteString("DIFFFILE1=DIFFFILE1.1\nDIFFFILE3=\"DIFFFILE3 with spaces\"\nDIFFFILE4=DIFFFILE4\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		diffCommand := commands.Diff{
+			Db:         db,
+			File:       tmp.Name(),
+			ShowValues: true,
+			IsJson:     true,
+			View:       &out,
+		}
+
+		err = diffCommand.Execute(ctx)
+		assert.ErrorIs(t, err, commands.ErrDifferent)
+
+		RESULT := []map[string]string{}
+		err = json.Unmarshal(out.Bytes(), &RESULT)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []map[string]string{
+			{
+				"key":    "DIFFFILE1",
+				"status": "changed",
+				"from":   "DIFFFILE1",
+				"to":     "DIFFFILE1.1",
+			},
+			{
+				"key":    "DIFFFILE2",
+				"status": "removed",
+				"from":   "DIFFFILE2",
+			},
+			{
+				"key":    "DIFFFILE4",
+				"status": "added",
+				"to":     "DIFFFILE4",
+			},
+		}, RESULT)
+
+		dumpCommand := commands.Dump{
+			File: tmp,
+		}
+
+		err = tmp.Truncate(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp.Seek(0, 0)
+
+		err = dumpCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sameDiffCommand := commands.Diff{
+			Db:   db,
+			File: tmp.Name(),
+			View: &bytes.Buffer{},
+		}
+
+		err = sameDiffCommand.Execute(ctx)
+		assert.NoError(t, err)
+	}
+}
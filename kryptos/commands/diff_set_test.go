@@ -0,0 +1,128 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "DIFF1",
+				Value:    "DIFF1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "DIFF1",
+				Value:    "DIFF1.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "DIFF2",
+				Value:    "DIFF2",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "DIFF3",
+				Value:    "DIFF3",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := bytes.Buffer{}
+		diffCommand := commands.Diff{
+			Db:     db,
+			From:   "test",
+			To:     "*",
+			IsJson: true,
+			View:   &out,
+		}
+
+		err = diffCommand.Execute(ctx)
+		assert.ErrorIs(t, err, commands.ErrDifferent)
+
+		RESULT := []map[string]string{}
+		err = json.Unmarshal(out.Bytes(), &RESULT)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []map[string]string{
+			{
+				"key":    "DIFF1",
+				"status": "changed",
+				"from":   kryptos.Fingerprint(envs[1].Value),
+				"to":     kryptos.Fingerprint(envs[0].Value),
+			},
+			{
+				"key":    "DIFF2",
+				"status": "removed",
+				"from":   kryptos.Fingerprint(envs[2].Value),
+			},
+		}, RESULT)
+		assert.NotContains(t, out.String(), envs[1].Value)
+
+		out = bytes.Buffer{}
+		reverseDiffCommand := commands.Diff{
+			Db:         db,
+			From:       "*",
+			To:         "test",
+			ShowValues: true,
+			View:       &out,
+		}
+
+		err = reverseDiffCommand.Execute(ctx)
+		assert.ErrorIs(t, err, commands.ErrDifferent)
+
+		assert.Regexp(t, `DIFF1\s+changed\s+DIFF1\s+DIFF1.1`, out.String())
+		assert.Regexp(t, `DIFF2\s+added\s+DIFF2`, out.String())
+		assert.NotContains(t, out.String(), envs[3].Key)
+
+		out = bytes.Buffer{}
+		sameDiffCommand := commands.Diff{
+			Db:   db,
+			From: "test",
+			To:   "test",
+			View: &out,
+		}
+
+		err = sameDiffCommand.Execute(ctx)
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,220 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrantSetGrep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{Db: db, Key: "GRANT_DB_HOST", Value: "GRANT_DB_HOST"},
+			{Db: db, Key: "GRANT_API_KEY", Value: "GRANT_API_KEY"},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// nothing is enforced until a principal is set
+		grants := []commands.Grant{
+			{Db: db, Principal: "alice", Permission: kryptos.PERMISSION_READ, Pattern: "GRANT_DB_*"},
+			{Db: db, Principal: "alice", Permission: kryptos.PERMISSION_WRITE, Pattern: "GRANT_DB_*"},
+			{Db: db, Principal: "alice", Permission: kryptos.PERMISSION_READ, Pattern: "GRANT_API_KEY"},
+		}
+
+		for _, command := range grants {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		unknownGrantCommand := commands.Grant{Db: db, Principal: "alice", Permission: "delete", Pattern: "*"}
+		err = unknownGrantCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrUnknownPermission)
+
+		revokeCommand := commands.Revoke{Db: db, Principal: "alice", Permission: kryptos.PERMISSION_READ, Pattern: "GRANT_API_KEY"}
+		err = revokeCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = revokeCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrGrantNotFound)
+
+		kryptos.SetPrincipal("alice")
+		defer kryptos.SetPrincipal("")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "GRANT_DB_HOST", "GRANT_DB_HOST")
+		assertGrep(t, ctx, "GRANT_API_KEY", "")
+
+		setEnvCommand := commands.SetEnv{Db: db, Key: "GRANT_DB_HOST", Value: "GRANT_DB_HOST.1"}
+		err = setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		denied := []interface{ Execute(context.Context) error }{
+			&commands.SetEnv{Db: db, Key: "GRANT_API_KEY", Value: "GRANT_API_KEY.1"},
+			&commands.SetEnv{Db: db, Key: "GRANT_DB_HOST", Value: "GRANT_DB_HOST.2", IsGlobal: true},
+			&commands.Rm{Db: db, Key: "GRANT_API_KEY"},
+			&commands.Mv{Db: db, Previous: "GRANT_DB_HOST", Next: "GRANT_API_KEY"},
+			&commands.Prune{Db: db, Offset: 0},
+			&commands.Grant{Db: db, Principal: "alice", Permission: kryptos.PERMISSION_ADMIN, Pattern: "*"},
+		}
+
+		for _, command := range denied {
+			err = command.Execute(ctx)
+			assert.ErrorIs(t, err, kryptos.ErrPermissionDenied)
+		}
+
+		out := bytes.Buffer{}
+		infoCommand := commands.Info{
+			Db:   db,
+			View: &out,
+		}
+
+		err = infoCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, out.String(), "Principal: alice")
+		assert.Contains(t, out.String(), "Permissions: read on test (GRANT_DB_*), write on test (GRANT_DB_*)")
+
+		kryptos.SetPrincipal("")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "GRANT_DB_HOST", "GRANT_DB_HOST.1")
+		assertGrep(t, ctx, "GRANT_API_KEY", "GRANT_API_KEY")
+
+		out.Reset()
+		err = infoCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, strings.Contains(out.String(), "Principal: none, grants are not enforced"))
+	}
+}
+
+func TestGrantInheritedSetGrep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		project := kryptos.Project()
+
+		setParentCommand := commands.ProjectSetParent{Db: db, Project: project, Parent: "grant_base"}
+		err = setParentCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kryptos.SetProject("grant_base")
+
+		inherited := []commands.SetEnv{
+			{Db: db, Key: "GRANT_PARENT", Value: "GRANT_PARENT"},
+			{Db: db, Key: "GRANT_GLOBAL", Value: "GRANT_GLOBAL", IsGlobal: true},
+		}
+
+		for _, command := range inherited {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		kryptos.SetProject(project)
+
+		setEnvCommand := commands.SetEnv{Db: db, Key: "GRANT_CHILD", Value: "GRANT_CHILD"}
+		err = setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a grant on the child covers the values set in the child only
+		grantCommand := commands.Grant{Db: db, Principal: "bob", Permission: kryptos.PERMISSION_READ, Pattern: "GRANT_*"}
+		err = grantCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kryptos.SetPrincipal("bob")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "GRANT_CHILD", "GRANT_CHILD")
+		assertGrep(t, ctx, "GRANT_PARENT", "")
+		assertGrep(t, ctx, "GRANT_GLOBAL", "")
+
+		envStats, err := kryptos.Stats(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []string{}
+		for _, envStat := range envStats {
+			keys = append(keys, envStat.Key)
+		}
+
+		assert.Equal(t, []string{"GRANT_CHILD"}, keys)
+
+		kryptos.SetPrincipal("")
+	}
+}
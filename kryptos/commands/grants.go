@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"skulpture/kryptos/kryptos"
+)
+
+type Grant struct {
+	Db         *sql.DB
+	Principal  string
+	Permission string
+	Pattern    string
+	IsGlobal   bool
+}
+
+// Allows the principal a permission on the keys of the project matching the pattern
+func (command *Grant) Execute(ctx context.Context) error {
+	return kryptos.AddGrant(ctx, command.Db, kryptos.Grant{
+		Principal:  command.Principal,
+		Permission: command.Permission,
+		Project:    scopeProject(command.IsGlobal),
+		Pattern:    command.Pattern,
+	})
+}
+
+type Revoke struct {
+	Db         *sql.DB
+	Principal  string
+	Permission string
+	Pattern    string
+	IsGlobal   bool
+}
+
+func (command *Revoke) Execute(ctx context.Context) error {
+	return kryptos.RemoveGrant(ctx, command.Db, kryptos.Grant{
+		Principal:  command.Principal,
+		Permission: command.Permission,
+		Project:    scopeProject(command.IsGlobal),
+		Pattern:    command.Pattern,
+	})
+}
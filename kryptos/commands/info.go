@@ -2,21 +2,65 @@ package commands
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"skulpture/kryptos/kryptos"
+	"strings"
 )
 
 type Info struct {
+	Db   *sql.DB
 	View io.Writer
 }
 
 func (command *Info) Execute(ctx context.Context) error {
+	retiredKeyIds := []string{}
+	for _, key := range kryptos.CurrentKeyring().Retired {
+		retiredKeyIds = append(retiredKeyIds, kryptos.KeyId(key))
+	}
+
+	keyProvider := kryptos.KEY_PROVIDER.Value()
+	if provider := kryptos.CurrentKeyring().Provider; provider != nil {
+		keyProvider = fmt.Sprintf("%s (%s)", keyProvider, provider.KeyId())
+	}
+
+	connectionString, _ := kryptos.DB_CONNECTION_STRING.Value()
+
+	agent := "not running"
+	if kryptos.IsAgentRunning() {
+		agent = fmt.Sprintf("running on %s", kryptos.AgentSocket())
+	}
+
+	principal := "none, grants are not enforced"
+	permissions := "all"
+	if kryptos.Principal() != "" && !kryptos.IsRemote() {
+		principal = kryptos.Principal()
+
+		grants, err := kryptos.EffectiveGrants(ctx, command.Db, kryptos.Project())
+		if err != nil {
+			return err
+		}
+
+		effective := []string{}
+		for _, grant := range grants {
+			effective = append(effective, grant.String())
+		}
+		permissions = strings.Join(effective, ", ")
+	}
+
 	info := []string{
-		fmt.Sprintf("Project: %s", kryptos.PROJECT.Value()),
+		fmt.Sprintf("Project: %s", kryptos.Project()),
+		fmt.Sprintf("Stage: %s", kryptos.Stage()),
 		fmt.Sprintf("Database driver: %s", kryptos.DB_DRIVER.Value()),
-		fmt.Sprintf("Database connection string: %s", kryptos.DB_CONNECTION_STRING.Value()),
-		fmt.Sprintf("Encryption key: %s", kryptos.ENCRYPTION_KEY.Value()),
+		fmt.Sprintf("Database connection string: %s", connectionString),
+		fmt.Sprintf("Encryption key: %s", kryptos.EncryptionKey()),
+		fmt.Sprintf("Encryption key id: %s", kryptos.KeyId(kryptos.EncryptionKey())),
+		fmt.Sprintf("Retired key ids: %s", strings.Join(retiredKeyIds, ", ")),
+		fmt.Sprintf("Key provider: %s", keyProvider),
+		fmt.Sprintf("Agent: %s", agent),
+		fmt.Sprintf("Principal: %s", principal),
+		fmt.Sprintf("Permissions: %s", permissions),
 		fmt.Sprintf("Version: v%s", kryptos.VERSION),
 	}
 
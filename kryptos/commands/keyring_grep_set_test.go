@@ -0,0 +1,202 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		previousKey := kryptos.EncryptionKey()
+
+		envelopeEnv := commands.SetEnv{
+			Db:       db,
+			Key:      "KEYRING1",
+			Value:    "KEYRING1",
+			IsGlobal: false,
+		}
+
+		err = envelopeEnv.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		legacyValue, err := legacyEncrypt("KEYRING2", previousKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, _ := uuid.NewV7()
+		_, err = db.ExecContext(ctx, "INSERT INTO environments(uuid, key, value, project, deprecated) VALUES($1, $2, $3, $4, 0);",
+			id.String(), "KEYRING2", legacyValue, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, "DELETE FROM kryptos_meta WHERE name = $1;", kryptos.META_BOUND_VALUES)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.BindValues(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nextKey, _ := RandomHex(32)
+		kryptos.SetEncryptionKey(nextKey)
+
+		assert.Contains(t, kryptos.CurrentKeyring().Retired, previousKey)
+
+		nextEnv := commands.SetEnv{
+			Db:       db,
+			Key:      "KEYRING3",
+			Value:    "KEYRING3",
+			IsGlobal: false,
+		}
+
+		err = nextEnv.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"KEYRING1", "KEYRING2", "KEYRING3"} {
+			out := bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  key,
+				View: &out,
+			}
+
+			err = grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			RESULT := strings.TrimSpace(out.String())
+			assert.Equal(t, key, RESULT)
+		}
+	}
+}
+
+func TestKeyringBindPending(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		otherKey, _ := RandomHex(32)
+		legacyValue, err := legacyEncrypt("KEYRING4", otherKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, _ := uuid.NewV7()
+		_, err = db.ExecContext(ctx, "INSERT INTO environments(uuid, key, value, project, deprecated) VALUES($1, $2, $3, $4, 0);",
+			id.String(), "KEYRING4", legacyValue, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, "DELETE FROM kryptos_meta WHERE name = $1;", kryptos.META_BOUND_VALUES)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, "INSERT INTO kryptos_meta(name, value) VALUES($1, '3');", kryptos.META_BIND_PENDING)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		meta := func(name string) bool {
+			count := 0
+			err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM kryptos_meta WHERE name = $1;", name).Scan(&count)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return count > 0
+		}
+
+		// the value is skipped without its key, binding stays pending for a client that has it
+		err = kryptos.BindValues(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, meta(kryptos.META_BIND_PENDING))
+		assert.False(t, meta(kryptos.META_BOUND_VALUES))
+
+		kryptos.SetEncryptionKey(otherKey)
+
+		err = kryptos.BindValues(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.False(t, meta(kryptos.META_BIND_PENDING))
+		assert.True(t, meta(kryptos.META_BOUND_VALUES))
+	}
+}
+
+// legacyEncrypt seals a value the way rows were written before ciphertexts had a header
+func legacyEncrypt(plain string, key string) (string, error) {
+	decodedKey, _ := hex.DecodeString(key)
+
+	block, err := aes.NewCipher(decodedKey)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	return hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
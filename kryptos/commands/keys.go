@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+)
+
+type KeysSplit struct {
+	Shares    int
+	Threshold int
+	View      io.Writer
+}
+
+// Splits the encryption key into shares, any threshold of them unseal it
+func (command *KeysSplit) Execute(ctx context.Context) error {
+	key := kryptos.EncryptionKey()
+	if key == "" {
+		return kryptos.ErrNoEncryptionKey
+	}
+
+	shares, err := kryptos.SplitKey(key, command.Shares, command.Threshold)
+	if err != nil {
+		return err
+	}
+
+	for _, share := range shares {
+		_, err = fmt.Fprintln(command.View, share)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Unseal struct {
+	Shares []string
+	View   io.Writer
+}
+
+// Rebuilds the encryption key from shares for this process only, it is never written to the environment
+func (command *Unseal) Execute(ctx context.Context) error {
+	shares := []kryptos.Share{}
+	for _, serialised := range command.Shares {
+		share, err := kryptos.ParseShare(serialised)
+		if err != nil {
+			return err
+		}
+
+		shares = append(shares, share)
+	}
+
+	key, err := kryptos.CombineShares(shares)
+	if err != nil {
+		return err
+	}
+
+	kryptos.SetEncryptionKey(key)
+
+	_, err = fmt.Fprintf(command.View, "Unsealed key id %s\n", kryptos.KeyId(key))
+
+	return err
+}
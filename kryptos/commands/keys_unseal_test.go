@@ -0,0 +1,68 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeysSplitUnseal(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		_, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		encryptionKey := kryptos.EncryptionKey()
+
+		out := bytes.Buffer{}
+		keysSplitCommand := commands.KeysSplit{
+			Shares:    5,
+			Threshold: 3,
+			View:      &out,
+		}
+
+		err = keysSplitCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shares := strings.Fields(out.String())
+		assert.Len(t, shares, 5)
+
+		out = bytes.Buffer{}
+		unsealCommand := commands.Unseal{
+			Shares: []string{shares[4], shares[0], shares[2]},
+			View:   &out,
+		}
+
+		err = unsealCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, encryptionKey, kryptos.EncryptionKey())
+		assert.Contains(t, out.String(), kryptos.KeyId(encryptionKey))
+
+		tooFewCommand := commands.Unseal{
+			Shares: shares[:2],
+			View:   &out,
+		}
+
+		err = tooFewCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrShareMismatch)
+	}
+}
@@ -0,0 +1,108 @@
+package commands_test
+
+import (
+	"context"
+	"os"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "LOAD1",
+				Value:    "LOAD1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "LOAD2",
+				Value:    "LOAD2",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loaded, err := kryptos.Load(context.Background(), kryptos.WithDb(db), kryptos.WithProject("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 2, loaded.Len())
+
+		value, _ := loaded.Get("LOAD2")
+		assert.Equal(t, "LOAD2", value)
+
+		global, err := kryptos.Load(context.Background(), kryptos.WithDb(db), kryptos.WithProject("*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := global.Get("LOAD2")
+		assert.False(t, ok)
+
+		t.Setenv("LOAD1", "OVERRIDE")
+		os.Unsetenv("LOAD2")
+
+		err = kryptos.Apply(loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv("LOAD2")
+
+		assert.Equal(t, "OVERRIDE", os.Getenv("LOAD1"))
+		assert.Equal(t, "LOAD2", os.Getenv("LOAD2"))
+
+		otherKey, _ := RandomHex(32)
+		_, err = kryptos.Load(context.Background(), kryptos.WithDb(db), kryptos.WithProject("test"), kryptos.WithKeyring(kryptos.Keyring{Current: otherKey}))
+		assert.ErrorIs(t, err, kryptos.ErrUnknownKey)
+
+		// a library caller without a key gets an error rather than an empty map
+		_, err = kryptos.Load(context.Background(), kryptos.WithDb(db), kryptos.WithProject("test"), kryptos.WithKeyring(kryptos.Keyring{}))
+		assert.ErrorIs(t, err, kryptos.ErrUnreadable)
+		assert.ErrorContains(t, err, "LOAD1, LOAD2")
+
+		skipped, err := kryptos.Load(context.Background(), kryptos.WithDb(db), kryptos.WithProject("test"), kryptos.WithKeyring(kryptos.Keyring{}), kryptos.WithUnreadableSkipped(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 0, skipped.Len())
+
+		// a context without the debug flag is enough to open the store
+		_, closeBackground, err := kryptos.Open(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeBackground()
+	}
+}
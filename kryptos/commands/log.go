@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+	"text/tabwriter"
+	"time"
+)
+
+type Log struct {
+	Db       *sql.DB
+	Key      string
+	IsGlobal bool
+	View     io.Writer
+}
+
+func (command *Log) Execute(ctx context.Context) error {
+	w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
+
+	fmt.Fprintln(w, "Version\tUuid\tCreated\tAuthor\tValue\tStatus")
+
+	versions, err := kryptos.History(ctx, command.Db, command.Key, command.IsGlobal)
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		status := "current"
+		if version.Deprecated {
+			status = "deprecated"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			version.Version,
+			version.Uuid,
+			version.CreatedAt.Format(time.RFC3339),
+			version.Author,
+			version.Fingerprint,
+			status)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "LOG1",
+				Value:    "LOG1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "LOG1",
+				Value:    "LOG1.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "LOG1",
+				Value:    "LOG1.2",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := bytes.Buffer{}
+		logCommand := commands.Log{
+			Db:       db,
+			Key:      "LOG1",
+			IsGlobal: false,
+			View:     &out,
+		}
+
+		err = logCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		RESULT := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, RESULT, 3)
+
+		LATEST := strings.Fields(RESULT[1])
+		assert.Equal(t, "2", LATEST[0])
+		assert.Equal(t, "test", LATEST[3])
+		assert.Equal(t, kryptos.Fingerprint(envs[1].Value), LATEST[4])
+		assert.Equal(t, "current", LATEST[5])
+
+		PREVIOUS := strings.Fields(RESULT[2])
+		assert.Equal(t, "1", PREVIOUS[0])
+		assert.Equal(t, kryptos.Fingerprint(envs[0].Value), PREVIOUS[4])
+		assert.Equal(t, "deprecated", PREVIOUS[5])
+
+		assert.NotContains(t, out.String(), envs[1].Value)
+		assert.NotContains(t, out.String(), kryptos.Fingerprint(envs[2].Value))
+
+		// fingerprints are keyed, another key fingerprints the same value differently
+		otherKey, _ := RandomHex(32)
+		assert.NotEqual(t, kryptos.Keyring{Current: otherKey}.Fingerprint(envs[1].Value), LATEST[4])
+	}
+}
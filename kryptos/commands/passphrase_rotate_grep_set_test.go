@@ -0,0 +1,105 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassphraseRotate(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "PASSPHRASE1",
+				Value:    "PASSPHRASE1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "PASSPHRASE2",
+				Value:    "PASSPHRASE2",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		previousKey := kryptos.EncryptionKey()
+
+		out := bytes.Buffer{}
+		rotateCommand := commands.Rotate{
+			Db:         db,
+			Passphrase: "correct horse battery staple",
+			View:       &out,
+		}
+
+		err = rotateCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotEqual(t, previousKey, kryptos.EncryptionKey())
+		assert.Contains(t, out.String(), "Rotated 2 rows")
+
+		derivedKey := kryptos.EncryptionKey()
+
+		out = bytes.Buffer{}
+		err = rotateCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, derivedKey, kryptos.EncryptionKey())
+		assert.Contains(t, out.String(), "Rotated 0 rows")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, env := range envs {
+			out = bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  env.Key,
+				View: &out,
+			}
+
+			err = grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			RESULT := strings.TrimSpace(out.String())
+			assert.Equal(t, env.Value, RESULT)
+		}
+	}
+}
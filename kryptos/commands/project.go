@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"skulpture/kryptos/kryptos"
+)
+
+type ProjectSetParent struct {
+	Db      *sql.DB
+	Project string
+	Parent  string
+}
+
+func (command *ProjectSetParent) Execute(ctx context.Context) error {
+	err := kryptos.SetParent(ctx, command.Db, command.Project, command.Parent)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectSetParentMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		grep := func(key string) string {
+			out := bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  key,
+				View: &out,
+			}
+
+			err := grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return strings.TrimSpace(out.String())
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "PROJECT1",
+				Value:    "PROJECT1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "PROJECT1",
+				Value:    "PROJECT1.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "PROJECT2",
+				Value:    "PROJECT2",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		mvProjectCommand := commands.Mv{
+			Db:        db,
+			Previous:  os.Getenv("PROJECT"),
+			Next:      "platform",
+			IsProject: true,
+		}
+
+		err = mvProjectCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[0].Value, grep("PROJECT1"))
+		assert.Empty(t, grep("PROJECT2"))
+
+		setParentCommand := commands.ProjectSetParent{
+			Db:      db,
+			Project: os.Getenv("PROJECT"),
+			Parent:  "platform",
+		}
+
+		err = setParentCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[1].Value, grep("PROJECT1"))
+		assert.Equal(t, envs[2].Value, grep("PROJECT2"))
+
+		overrideCommand := commands.SetEnv{
+			Db:    db,
+			Key:   "PROJECT2",
+			Value: "PROJECT2.1",
+		}
+
+		err = overrideCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, overrideCommand.Value, grep("PROJECT2"))
+
+		out := bytes.Buffer{}
+		statCommand := commands.Stat{
+			Db:   db,
+			View: &out,
+		}
+
+		err = statCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Regexp(t, `PROJECT1\s+platform\s+1`, out.String())
+		assert.Regexp(t, `PROJECT2\s+test\s+1`, out.String())
+
+		cycleCommand := commands.ProjectSetParent{
+			Db:      db,
+			Project: "platform",
+			Parent:  os.Getenv("PROJECT"),
+		}
+
+		err = cycleCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrProjectCycle)
+
+		detachCommand := commands.ProjectSetParent{
+			Db:      db,
+			Project: os.Getenv("PROJECT"),
+			Parent:  "*",
+		}
+
+		err = detachCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[0].Value, grep("PROJECT1"))
+	}
+}
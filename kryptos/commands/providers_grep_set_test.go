@@ -0,0 +1,250 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyProviders(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+	defer kryptos.SetKeyProvider(nil)
+
+	transit := newFakeTransit("root")
+	defer transit.Close()
+
+	fileKey, _ := RandomHex(32)
+	keyFile := filepath.Join(t.TempDir(), "kryptos.key")
+	err := os.WriteFile(keyFile, []byte(fileKey+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileProvider, err := kryptos.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := map[string]kryptos.KeyProvider{
+		"file": fileProvider,
+		"vault": &kryptos.VaultTransitProvider{
+			Address: transit.URL,
+			Token:   "root",
+			Key:     "kryptos",
+		},
+	}
+
+	for name, provider := range providers {
+		for driver, init := range DBs {
+			t.Logf("provider: %s, database: %s", name, driver)
+
+			stop := init(t)
+			defer stop()
+
+			kryptos.SetKeyProvider(provider)
+
+			db, close, err := kryptos.Open(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer close()
+
+			err = kryptos.GetEnvs(ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			setEnvCommand := commands.SetEnv{
+				Db:       db,
+				Key:      "PROVIDER1",
+				Value:    "PROVIDER1",
+				IsGlobal: false,
+			}
+
+			err = setEnvCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keyId string
+			err = db.QueryRowContext(ctx, "SELECT key_id FROM environments WHERE key = $1;", setEnvCommand.Key).Scan(&keyId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, provider.KeyId(), keyId)
+
+			err = kryptos.GetEnvs(ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  setEnvCommand.Key,
+				View: &out,
+			}
+
+			err = grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			RESULT := strings.TrimSpace(out.String())
+			assert.Equal(t, setEnvCommand.Value, RESULT)
+
+			// data keys unwrapped by the provider are not unwrapped again
+			err = kryptos.GetEnvs(ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// rotating to a raw key would move the rows off the provider
+			next, _ := RandomHex(32)
+			_, err = kryptos.RotateKey(ctx, db, next, 100, false)
+			assert.ErrorIs(t, err, kryptos.ErrProviderRotation)
+
+			err = db.QueryRowContext(ctx, "SELECT key_id FROM environments WHERE key = $1;", setEnvCommand.Key).Scan(&keyId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, provider.KeyId(), keyId)
+			assert.NotEqual(t, next, kryptos.EncryptionKey())
+
+			kryptos.SetKeyProvider(nil)
+		}
+	}
+
+	assert.Equal(t, 1, transit.Count("encrypt"))
+	assert.Equal(t, 1, transit.Count("decrypt"))
+}
+
+func TestKeyProviderCacheTtl(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+	defer kryptos.SetKeyProvider(nil)
+
+	transit := newFakeTransit("root")
+	defer transit.Close()
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		kryptos.SetKeyProvider(&kryptos.VaultTransitProvider{
+			Address:  transit.URL,
+			Token:    "root",
+			Key:      "kryptos",
+			CacheTtl: time.Millisecond,
+		})
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		setEnvCommand := commands.SetEnv{Db: db, Key: "PROVIDER_TTL", Value: "PROVIDER_TTL"}
+		err = setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// data keys are unwrapped again once they expired
+		for range 2 {
+			err = kryptos.GetEnvs(ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertGrep(t, ctx, "PROVIDER_TTL", "PROVIDER_TTL")
+
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+
+	assert.Equal(t, 2*len(DBs), transit.Count("decrypt"))
+}
+
+// fakeTransit implements the encrypt and decrypt endpoints of the Vault transit engine
+type fakeTransit struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	plaintexts []string
+	calls      map[string]int
+}
+
+func newFakeTransit(token string) *fakeTransit {
+	transit := &fakeTransit{
+		calls: map[string]int{},
+	}
+
+	transit.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+
+			return
+		}
+
+		var request map[string]string
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		transit.mutex.Lock()
+		defer transit.mutex.Unlock()
+
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/kryptos":
+			transit.calls["encrypt"]++
+			transit.plaintexts = append(transit.plaintexts, request["plaintext"])
+			data["ciphertext"] = fmt.Sprintf("vault:v1:%d", len(transit.plaintexts)-1)
+		case "/v1/transit/decrypt/kryptos":
+			transit.calls["decrypt"]++
+
+			var index int
+			_, err = fmt.Sscanf(request["ciphertext"], "vault:v1:%d", &index)
+			if err != nil || index >= len(transit.plaintexts) {
+				http.Error(w, `{"errors":["invalid ciphertext"]}`, http.StatusBadRequest)
+
+				return
+			}
+
+			data["plaintext"] = transit.plaintexts[index]
+		default:
+			http.NotFound(w, r)
+
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+
+	return transit
+}
+
+func (transit *fakeTransit) Count(operation string) int {
+	transit.mutex.Lock()
+	defer transit.mutex.Unlock()
+
+	return transit.calls[operation]
+}
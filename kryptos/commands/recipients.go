@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+	"text/tabwriter"
+)
+
+type RecipientsAdd struct {
+	Db        *sql.DB
+	Name      string
+	PublicKey string
+	IsGlobal  bool
+	View      io.Writer
+}
+
+// Seals every value in the project, including previous versions, to the recipients
+func (command *RecipientsAdd) Execute(ctx context.Context) error {
+	resealed, err := kryptos.AddRecipient(ctx, command.Db, scopeProject(command.IsGlobal), command.Name, command.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(command.View, "Resealed %d values\n", resealed)
+
+	return err
+}
+
+type RecipientsRm struct {
+	Db       *sql.DB
+	Name     string
+	IsGlobal bool
+	View     io.Writer
+}
+
+func (command *RecipientsRm) Execute(ctx context.Context) error {
+	resealed, err := kryptos.RemoveRecipient(ctx, command.Db, scopeProject(command.IsGlobal), command.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(command.View, "Resealed %d values with fresh data keys, rotate the secrets %s could read to revoke its access\n", resealed, command.Name)
+
+	return err
+}
+
+type RecipientsLs struct {
+	Db       *sql.DB
+	IsGlobal bool
+	View     io.Writer
+}
+
+func (command *RecipientsLs) Execute(ctx context.Context) error {
+	w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
+
+	fmt.Fprintln(w, "Name\tKey id\tPublic key")
+
+	recipients, err := kryptos.Recipients(ctx, command.Db, scopeProject(command.IsGlobal))
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", recipient.Name, kryptos.KeyId(recipient.PublicKey), recipient.PublicKey)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type RecipientsKeygen struct {
+	View io.Writer
+}
+
+// Prints a new identity, the identity goes in IDENTITY and the public key is added as a recipient
+func (command *RecipientsKeygen) Execute(ctx context.Context) error {
+	identity, publicKey, err := kryptos.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(command.View, "Identity: %s\nPublic key: %s\n", identity, publicKey)
+
+	return err
+}
+
+func scopeProject(isGlobal bool) string {
+	if isGlobal {
+		return "*"
+	}
+
+	return kryptos.Project()
+}
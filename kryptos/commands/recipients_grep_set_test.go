@@ -0,0 +1,184 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientsMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "RECIPIENTS1",
+				Value:    "RECIPIENTS1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "RECIPIENTS2",
+				Value:    "RECIPIENTS2",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		identity, publicKey, err := kryptos.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		recipientsAddCommand := commands.RecipientsAdd{
+			Db:        db,
+			Name:      "ci",
+			PublicKey: publicKey,
+			View:      &out,
+		}
+
+		err = recipientsAddCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, out.String(), "Resealed 1 values")
+
+		out = bytes.Buffer{}
+		recipientsLsCommand := commands.RecipientsLs{
+			Db:   db,
+			View: &out,
+		}
+
+		err = recipientsLsCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, out.String(), publicKey)
+
+		writeOnlyEnv := commands.SetEnv{
+			Db:       db,
+			Key:      "RECIPIENTS3",
+			Value:    "RECIPIENTS3",
+			IsGlobal: false,
+		}
+
+		err = writeOnlyEnv.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := kryptos.ENVS.Get("RECIPIENTS1")
+		assert.False(t, ok)
+
+		_, ok = kryptos.ENVS.Get("RECIPIENTS3")
+		assert.False(t, ok)
+
+		value, _ := kryptos.ENVS.Get("RECIPIENTS2")
+		assert.Equal(t, "RECIPIENTS2", value)
+
+		kryptos.AddIdentity(identity)
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"RECIPIENTS1", "RECIPIENTS2", "RECIPIENTS3"} {
+			out = bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  key,
+				View: &out,
+			}
+
+			err = grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			RESULT := strings.TrimSpace(out.String())
+			assert.Equal(t, key, RESULT)
+		}
+
+		var sealed string
+		err = db.QueryRowContext(ctx, "SELECT value FROM environments WHERE key = 'RECIPIENTS1';").Scan(&sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out = bytes.Buffer{}
+		recipientsRmCommand := commands.RecipientsRm{
+			Db:   db,
+			Name: "ci",
+			View: &out,
+		}
+
+		err = recipientsRmCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, out.String(), "Resealed 2 values")
+
+		// a data key unwrapped by the removed recipient no longer opens the value
+		var resealed string
+		err = db.QueryRowContext(ctx, "SELECT value FROM environments WHERE key = 'RECIPIENTS1';").Scan(&resealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotEqual(t, sealed, resealed)
+
+		// drops the identity, values are sealed with the encryption key again once the last recipient is removed
+		kryptos.ResetOverrides()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertGrep(t, ctx, "RECIPIENTS1", "RECIPIENTS1")
+
+		versions, err := kryptos.History(ctx, db, "RECIPIENTS3", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, versions, 1)
+	}
+}
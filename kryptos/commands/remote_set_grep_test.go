@@ -0,0 +1,149 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"skulpture/kryptos/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteSetGrep(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		token, err := kryptos.CreateToken(ctx, db, "test", "remote", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		api := server.Server{
+			Db:      db,
+			Driver:  driver,
+			Keyring: kryptos.CurrentKeyring(),
+		}
+
+		httpServer := httptest.NewServer(api.Handler())
+		defer httpServer.Close()
+
+		kryptos.SetRemote(&kryptos.Remote{
+			Url:   httpServer.URL,
+			Token: token,
+		})
+		defer kryptos.SetRemote(nil)
+
+		envs := []commands.SetEnv{
+			{
+				Key:   "REMOTE1",
+				Value: "REMOTE1",
+			},
+			{
+				Key:   "REMOTE2",
+				Value: "with spaces",
+			},
+			{
+				Key:   "REMOTE1",
+				Value: "REMOTE1.1",
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = kryptos.GetEnvs(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		grepCommand := commands.Grep{
+			Key:  "REMOTE1",
+			View: &out,
+		}
+
+		err = grepCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "REMOTE1.1", strings.TrimSpace(out.String()))
+
+		mvCommand := commands.Mv{
+			Previous: "REMOTE2",
+			Next:     "REMOTE3",
+		}
+
+		err = mvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rmCommand := commands.Rm{
+			Key: "REMOTE1",
+		}
+
+		err = rmCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []string{"REMOTE3"}, kryptos.ENVS.Keys())
+
+		value, _ := kryptos.ENVS.Get("REMOTE3")
+		assert.Equal(t, "with spaces", value)
+
+		_, err = kryptos.History(ctx, nil, "REMOTE3", false)
+		assert.ErrorIs(t, err, kryptos.ErrRemoteUnsupported)
+
+		remote := kryptos.Remote{
+			Url:   httpServer.URL,
+			Token: token,
+		}
+
+		_, ok, err := remote.Get(ctx, "REMOTE1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.False(t, ok)
+
+		// a wrong path prefix is not an empty project
+		kryptos.SetRemote(&kryptos.Remote{
+			Url:   httpServer.URL + "/kryptos",
+			Token: token,
+		})
+
+		err = kryptos.GetEnvs(ctx, nil)
+		assert.ErrorIs(t, err, kryptos.ErrRemote)
+
+		kryptos.SetRemote(&kryptos.Remote{
+			Url:   httpServer.URL,
+			Token: "kryptos_invalid",
+		})
+
+		err = kryptos.GetEnvs(ctx, nil)
+		assert.ErrorIs(t, err, kryptos.ErrInvalidToken)
+	}
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"skulpture/kryptos/kryptos"
+)
+
+type Rollback struct {
+	Db       *sql.DB
+	Key      string
+	To       string
+	IsGlobal bool
+}
+
+func (command *Rollback) Execute(ctx context.Context) error {
+	err := kryptos.RollbackEnv(ctx, command.Db, command.Key, command.To, command.IsGlobal)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "ROLLBACK1",
+				Value:    "ROLLBACK1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "ROLLBACK1",
+				Value:    "ROLLBACK1.1",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "ROLLBACK1",
+				Value:    "ROLLBACK1.2",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "ROLLBACK2",
+				Value:    "ROLLBACK2",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "ROLLBACK2",
+				Value:    "ROLLBACK2.1",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		grep := func(key string) string {
+			out := bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  key,
+				View: &out,
+			}
+
+			err := grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return strings.TrimSpace(out.String())
+		}
+
+		rollbackPreviousCommand := commands.Rollback{
+			Db:       db,
+			Key:      "ROLLBACK1",
+			IsGlobal: false,
+		}
+		err = rollbackPreviousCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[1].Value, grep("ROLLBACK1"))
+
+		rollbackVersionCommand := commands.Rollback{
+			Db:       db,
+			Key:      "ROLLBACK1",
+			To:       "1",
+			IsGlobal: false,
+		}
+		err = rollbackVersionCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[0].Value, grep("ROLLBACK1"))
+
+		versions, err := kryptos.History(ctx, db, "ROLLBACK1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, versions, 5)
+		assert.Equal(t, kryptos.Fingerprint(envs[0].Value), versions[0].Fingerprint)
+
+		rollbackUuidCommand := commands.Rollback{
+			Db:       db,
+			Key:      "ROLLBACK1",
+			To:       versions[2].Uuid,
+			IsGlobal: false,
+		}
+		err = rollbackUuidCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[2].Value, grep("ROLLBACK1"))
+
+		rollbackGlobalCommand := commands.Rollback{
+			Db:       db,
+			Key:      "ROLLBACK2",
+			IsGlobal: true,
+		}
+		err = rollbackGlobalCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[3].Value, grep("ROLLBACK2"))
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[2].Value, grep("ROLLBACK1"))
+		assert.Equal(t, envs[3].Value, grep("ROLLBACK2"))
+
+		rollbackMissingCommand := commands.Rollback{
+			Db:       db,
+			Key:      "ROLLBACK1",
+			To:       "42",
+			IsGlobal: false,
+		}
+		err = rollbackMissingCommand.Execute(ctx)
+		assert.ErrorIs(t, err, kryptos.ErrVersionNotFound)
+	}
+}
@@ -3,24 +3,54 @@ package commands
 import (
 	"context"
 	"database/sql"
-	"os"
+	"fmt"
+	"io"
 	"skulpture/kryptos/kryptos"
 )
 
 type Rotate struct {
 	Db            *sql.DB
 	EncryptionKey string
+	Passphrase    string
+	BatchSize     int
+	IsDryRun      bool
+	View          io.Writer
 }
 
+// Re-wraps data keys in place with the key, or the key derived from the passphrase,
+// and verifies every row opens with it, values and versions are left untouched.
+// The new key only applies to this process, the other clients are told which key id to switch to
+// without it being printed
 func (command *Rotate) Execute(ctx context.Context) error {
-	os.Setenv("ENCRYPTION_KEY", command.EncryptionKey)
+	var report kryptos.RotateReport
+	var err error
+	if command.Passphrase != "" {
+		report, err = kryptos.RotatePassphrase(ctx, command.Db, command.Passphrase, command.BatchSize, command.IsDryRun)
+	} else {
+		report, err = kryptos.RotateKey(ctx, command.Db, command.EncryptionKey, command.BatchSize, command.IsDryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.IsDryRun {
+		_, err = fmt.Fprintf(command.View, "Would rotate %d rows to key id %s\n", report.Rotated, report.KeyId)
+
+		return err
+	}
 
-	for key, value := range kryptos.ENVS.Iterator() {
-		err := kryptos.SetEnv(ctx, command.Db, key, value, false)
-		if err != nil {
-			return err
-		}
+	_, err = fmt.Fprintf(command.View, "Rotated %d rows to key id %s, verified %d rows\n", report.Rotated, report.KeyId, report.Verified)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	if command.Passphrase != "" {
+		_, err = fmt.Fprintln(command.View, "Set ENCRYPTION_PASSPHRASE to the new passphrase in every client")
+
+		return err
+	}
+
+	_, err = fmt.Fprintf(command.View, "Set ENCRYPTION_KEY in every client to the key passed with --encryption-key, key id %s\n", report.KeyId)
+
+	return err
 }
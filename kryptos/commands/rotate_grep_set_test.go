@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,13 +60,52 @@ func TestRotateMixed(t *testing.T) {
 			}
 		}
 
+		// values of a stage are bound to it and rotated with the rest
+		kryptos.SetStage("dev")
+		defer kryptos.SetStage("")
+
+		stagedEnv := commands.SetEnv{
+			Db:    db,
+			Key:   "ROTATE3",
+			Value: "ROTATE3",
+		}
+
+		err = stagedEnv.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kryptos.SetStage("")
+
 		GLOBAL_ENV_DECLARATION := envs[2]
 		PROJECT_ENV_DECLARATION := envs[1]
 
+		previousKey := kryptos.EncryptionKey()
 		encryptionKey, _ := RandomHex(32)
+
+		out := bytes.Buffer{}
+		dryRunCommand := commands.Rotate{
+			Db:            db,
+			EncryptionKey: encryptionKey,
+			BatchSize:     2,
+			IsDryRun:      true,
+			View:          &out,
+		}
+
+		err = dryRunCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, previousKey, kryptos.EncryptionKey())
+		assert.Contains(t, out.String(), "Would rotate 4 rows")
+
+		out = bytes.Buffer{}
 		rotateCommand := commands.Rotate{
 			Db:            db,
 			EncryptionKey: encryptionKey,
+			BatchSize:     2,
+			View:          &out,
 		}
 
 		err = rotateCommand.Execute(ctx)
@@ -73,7 +113,24 @@ func TestRotateMixed(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		out := bytes.Buffer{}
+		assert.Equal(t, encryptionKey, kryptos.EncryptionKey())
+		assert.Contains(t, out.String(), "Rotated 4 rows")
+		assert.Contains(t, out.String(), "verified 4 rows")
+		assert.NotContains(t, out.String(), encryptionKey)
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		versions, err := kryptos.History(ctx, db, GLOBAL_ENV_DECLARATION.Key, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, versions, 2)
+
+		out = bytes.Buffer{}
 		grepProjectEnvCommand := commands.Grep{
 			Key:  PROJECT_ENV_DECLARATION.Key,
 			View: &out,
@@ -100,5 +157,81 @@ func TestRotateMixed(t *testing.T) {
 
 		RESULT = strings.TrimSpace(out.String())
 		assert.Equal(t, GLOBAL_ENV_DECLARATION.Value, RESULT)
+
+		kryptos.SetStage("dev")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, _ := kryptos.ENVS.Get(stagedEnv.Key)
+		assert.Equal(t, stagedEnv.Value, value)
+
+		kryptos.SetStage("")
+	}
+}
+
+func TestRotateIncomplete(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		for _, key := range []string{"ROTATE1", "ROTATE2"} {
+			setCommand := commands.SetEnv{
+				Db:    db,
+				Key:   key,
+				Value: key,
+			}
+
+			err = setCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// a row wrapped by a key the keyring does not have stops the rotation after the batches before it
+		id, _ := uuid.NewV7()
+		_, err = db.ExecContext(ctx, "INSERT INTO environments(uuid, key, value, data_key, key_id, project, stage, deprecated) VALUES($1, $2, $3, $4, $5, $6, '', 0);",
+			id.String(), "ROTATE3", "v3:aes-256-gcm:00000000:00", "v3:aes-256-gcm:deadbeef:00", "deadbeef", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		previousKey := kryptos.EncryptionKey()
+		encryptionKey, _ := RandomHex(32)
+
+		report, err := kryptos.RotateKey(ctx, db, encryptionKey, 1, false)
+		assert.ErrorIs(t, err, kryptos.ErrRotationIncomplete)
+		assert.ErrorIs(t, err, kryptos.ErrUnknownKey)
+		assert.Equal(t, 2, report.Rotated)
+		assert.Equal(t, 1, report.Remaining)
+		assert.Equal(t, previousKey, kryptos.EncryptionKey())
+
+		_, err = db.ExecContext(ctx, "DELETE FROM environments WHERE key = $1;", "ROTATE3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// run again with the same key the rotation resumes
+		report, err = kryptos.RotateKey(ctx, db, encryptionKey, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 0, report.Rotated)
+		assert.Equal(t, 0, report.Remaining)
+		assert.Equal(t, 2, report.Verified)
+		assert.Equal(t, encryptionKey, kryptos.EncryptionKey())
 	}
 }
@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"skulpture/kryptos/kryptos"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+var (
+	ErrNoCommand     = errors.New("no command to run")
+	ErrUnknownSignal = errors.New("unknown signal")
+)
+
+type Run struct {
+	Db      *sql.DB
+	Command []string
+	// Environ is the environment the command starts with, kryptos.CONFIG_ENVS are removed from it
+	Environ []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+
+	// IsWatching polls the project every Interval, when a value changes the command is sent
+	// ReloadSignal, or restarted with the new values when there is none
+	IsWatching   bool
+	Interval     time.Duration
+	ReloadSignal os.Signal
+	GracePeriod  time.Duration
+}
+
+// Execute starts the command with ENVS added to Environ, values are passed as they are so
+// spaces, quotes and newlines survive. Signals are forwarded until the command exits
+func (command *Run) Execute(ctx context.Context) error {
+	if len(command.Command) == 0 {
+		return ErrNoCommand
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	// ENVS is updated in place by writes, keep the values the command was started with
+	envs := kryptos.ENVS.Copy()
+
+	child, exited, err := command.start(envs)
+	if err != nil {
+		return err
+	}
+
+	var poll <-chan time.Time
+	if command.IsWatching {
+		interval := command.Interval
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case received := <-signals:
+			child.Process.Signal(received)
+		case err := <-exited:
+			return err
+		case <-poll:
+			next, err := kryptos.ResolveEnvs(ctx, command.Db, kryptos.Project(), kryptos.Stage())
+			if err != nil {
+				command.log("could not poll %s: %s", kryptos.Project(), err)
+
+				continue
+			}
+
+			if isEqual(envs, next) {
+				continue
+			}
+
+			envs = next
+
+			if command.ReloadSignal != nil {
+				command.log("values changed, sending %s", command.ReloadSignal)
+				child.Process.Signal(command.ReloadSignal)
+
+				continue
+			}
+
+			command.log("values changed, restarting")
+			command.stop(child, exited)
+
+			child, exited, err = command.start(envs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (command *Run) start(envs *orderedmap.OrderedMap[string, string]) (*exec.Cmd, chan error, error) {
+	env := []string{}
+	for _, entry := range command.Environ {
+		key, _, _ := strings.Cut(entry, "=")
+		if slices.Contains(kryptos.CONFIG_ENVS, key) {
+			continue
+		}
+
+		env = append(env, entry)
+	}
+
+	for key, value := range envs.Iterator() {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	child := exec.Command(command.Command[0], command.Command[1:]...)
+	child.Env = env
+	child.Stdin = command.Stdin
+	child.Stdout = command.Stdout
+	child.Stderr = command.Stderr
+
+	err := child.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- child.Wait()
+	}()
+
+	return child, exited, nil
+}
+
+// stop sends SIGTERM and kills the command when it has not exited after GracePeriod
+func (command *Run) stop(child *exec.Cmd, exited chan error) {
+	gracePeriod := command.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = 10 * time.Second
+	}
+
+	child.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-exited:
+	case <-time.After(gracePeriod):
+		child.Process.Kill()
+		<-exited
+	}
+}
+
+func (command *Run) log(format string, args ...any) {
+	if command.Stderr != nil {
+		fmt.Fprintf(command.Stderr, "kryptos: %s\n", fmt.Sprintf(format, args...))
+	}
+}
+
+func isEqual(previous *orderedmap.OrderedMap[string, string], next *orderedmap.OrderedMap[string, string]) bool {
+	if previous.Len() != next.Len() {
+		return false
+	}
+
+	for key, value := range next.Iterator() {
+		previousValue, ok := previous.Get(key)
+		if !ok || previousValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseSignal accepts HUP, INT, QUIT and TERM with or without the SIG prefix, or a signal number
+func ParseSignal(name string) (os.Signal, error) {
+	signals := map[string]os.Signal{
+		"HUP":  syscall.SIGHUP,
+		"INT":  syscall.SIGINT,
+		"QUIT": syscall.SIGQUIT,
+		"TERM": syscall.SIGTERM,
+	}
+
+	parsed, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if ok {
+		return parsed, nil
+	}
+
+	number, err := strconv.Atoi(name)
+	if err != nil || number < 1 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSignal, name)
+	}
+
+	return syscall.Signal(number), nil
+}
+
+// ExitCode is the code a command run by Run exited with, a command killed by a signal exits with 128 + the signal
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+
+	return exitErr.ExitCode(), true
+}
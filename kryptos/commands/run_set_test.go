@@ -0,0 +1,147 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSet(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ENV_DECLARATION := commands.SetEnv{
+			Db:    db,
+			Key:   "RUN1",
+			Value: "it's \"quoted\"\nand $(not) expanded",
+		}
+
+		err = ENV_DECLARATION.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		runCommand := commands.Run{
+			Command: []string{"sh", "-c", `printf "%s" "$RUN1"`},
+			Environ: []string{"PATH=" + os.Getenv("PATH")},
+			Stdout:  &out,
+		}
+
+		err = runCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, ENV_DECLARATION.Value, out.String())
+
+		// the configuration of kryptos is not passed on to the command
+		out.Reset()
+		configCommand := commands.Run{
+			Command: []string{"sh", "-c", `printf "%s %s %s" "${ENCRYPTION_KEY-unset}" "${API_TOKEN-unset}" "$RUN1"`},
+			Environ: []string{"PATH=" + os.Getenv("PATH"), "ENCRYPTION_KEY=secret", "API_TOKEN=secret"},
+			Stdout:  &out,
+		}
+
+		err = configCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "unset unset "+ENV_DECLARATION.Value, out.String())
+
+		exitCommand := commands.Run{
+			Command: []string{"sh", "-c", "exit 3"},
+			Environ: []string{"PATH=" + os.Getenv("PATH")},
+		}
+
+		err = exitCommand.Execute(ctx)
+		code, ok := commands.ExitCode(err)
+
+		assert.True(t, ok)
+		assert.Equal(t, 3, code)
+
+		err = (&commands.Run{}).Execute(ctx)
+		assert.ErrorIs(t, err, commands.ErrNoCommand)
+	}
+}
+
+func TestRunWatchSet(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		err := kryptos.SetEnv(ctx, db, "WATCH1", "one", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.Buffer{}
+		runCommand := commands.Run{
+			Db:          db,
+			Command:     []string{"sh", "-c", `echo "$WATCH1"; [ "$WATCH1" = two ] && exit 0; exec sleep 5`},
+			Environ:     []string{"PATH=" + os.Getenv("PATH")},
+			Stdout:      &out,
+			IsWatching:  true,
+			Interval:    50 * time.Millisecond,
+			GracePeriod: time.Second,
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- runCommand.Execute(ctx)
+		}()
+
+		time.Sleep(200 * time.Millisecond)
+
+		err = kryptos.SetEnv(ctx, db, "WATCH1", "two", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err = <-exited:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("command was not restarted")
+		}
+
+		assert.Equal(t, "one\ntwo\n", out.String())
+	}
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"skulpture/kryptos/kryptos"
+	"skulpture/kryptos/server"
+	"syscall"
+	"time"
+)
+
+var ErrTlsKeyPair = errors.New("--tls-cert and --tls-key must be given together")
+
+type Serve struct {
+	Db      *sql.DB
+	Listen  string
+	TlsCert string
+	TlsKey  string
+	View    io.Writer
+}
+
+// Serves the API until interrupted, over TLS when a certificate is given. Requests in flight are given time to finish
+func (command *Serve) Execute(ctx context.Context) error {
+	if (command.TlsCert == "") != (command.TlsKey == "") {
+		return ErrTlsKeyPair
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	api := server.Server{
+		Db:      command.Db,
+		Driver:  kryptos.DB_DRIVER.Value(),
+		Keyring: kryptos.CurrentKeyring(),
+	}
+
+	listener, err := net.Listen("tcp", command.Listen)
+	if err != nil {
+		return err
+	}
+
+	httpServer := http.Server{
+		Handler: api.Handler(),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	_, err = fmt.Fprintf(command.View, "Listening on %s\n", listener.Addr())
+	if err != nil {
+		return err
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if command.TlsCert != "" {
+			served <- httpServer.ServeTLS(listener, command.TlsCert, command.TlsKey)
+
+			return
+		}
+
+		served <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		return err
+	}
+
+	err = <-served
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,165 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageMixed(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+		defer kryptos.SetStage("")
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		grep := func(key string) string {
+			out := bytes.Buffer{}
+			grepCommand := commands.Grep{
+				Key:  key,
+				View: &out,
+			}
+
+			err := grepCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return strings.TrimSpace(out.String())
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "STAGE1",
+				Value:    "STAGE1",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "STAGE2",
+				Value:    "STAGE2",
+				IsGlobal: true,
+			},
+			{
+				Db:       db,
+				Key:      "STAGE2",
+				Value:    "STAGE2.1",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		kryptos.SetStage("dev")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[0].Value, grep("STAGE1"))
+		assert.Equal(t, envs[2].Value, grep("STAGE2"))
+
+		stagedEnvs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "STAGE2",
+				Value:    "STAGE2.2",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "STAGE3",
+				Value:    "STAGE3",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "STAGE2",
+				Value:    "STAGE2.3",
+				IsGlobal: true,
+			},
+		}
+
+		for _, command := range stagedEnvs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		assert.Equal(t, stagedEnvs[0].Value, grep("STAGE2"))
+		assert.Equal(t, stagedEnvs[1].Value, grep("STAGE3"))
+
+		out := bytes.Buffer{}
+		statCommand := commands.Stat{
+			Db:   db,
+			View: &out,
+		}
+
+		err = statCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Regexp(t, `STAGE1\s+\*\s+1`, out.String())
+		assert.Regexp(t, `STAGE2\s+test\s+dev\s+1`, out.String())
+		assert.Regexp(t, `STAGE3\s+test\s+dev\s+1`, out.String())
+
+		kryptos.SetStage("")
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[2].Value, grep("STAGE2"))
+		assert.Empty(t, grep("STAGE3"))
+
+		kryptos.SetStage("dev")
+
+		rmCommand := commands.Rm{
+			Db:                db,
+			Key:               "STAGE2",
+			IncludeDeprecated: true,
+			IncludeGlobal:     false,
+		}
+
+		err = rmCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, envs[2].Value, grep("STAGE2"))
+	}
+}
@@ -17,7 +17,7 @@ type Stat struct {
 func (command *Stat) Execute(ctx context.Context) error {
 	w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
 
-	fmt.Fprintln(w, "Key\tProject\tVersions")
+	fmt.Fprintln(w, "Key\tProject\tStage\tVersions")
 
 	envStats, err := kryptos.Stats(ctx, command.Db)
 	if err != nil {
@@ -25,7 +25,7 @@ func (command *Stat) Execute(ctx context.Context) error {
 	}
 
 	for _, stat := range envStats {
-		fmt.Fprintf(w, "%s\t%s\t%d\n", stat.Key, stat.Project, stat.Count)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", stat.Key, stat.Project, stat.Stage, stat.Count)
 	}
 
 	err = w.Flush()
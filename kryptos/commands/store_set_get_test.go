@@ -0,0 +1,135 @@
+package commands_test
+
+import (
+	"context"
+	"fmt"
+	"skulpture/kryptos/kryptos"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreConcurrent(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, closeDb := openMemoryDb(t, ctx)
+		defer closeDb()
+
+		encryptionKey, _ := RandomHex(32)
+		keyring := kryptos.Keyring{Current: encryptionKey}
+
+		stores := []*kryptos.Store{
+			kryptos.NewStore(kryptos.WithDb(db), kryptos.WithDriver(driver), kryptos.WithProject("store1"), kryptos.WithKeyring(keyring)),
+			kryptos.NewStore(kryptos.WithDb(db), kryptos.WithDriver(driver), kryptos.WithProject("store2"), kryptos.WithKeyring(keyring)),
+		}
+
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 40)
+		for i := range 10 {
+			for _, store := range stores {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					key := fmt.Sprintf("STORE%d", i)
+					err := store.Set(ctx, key, fmt.Sprintf("%s.%d", store.Project(), i), false)
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					value, ok, err := store.Get(ctx, key)
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					if !ok || value != fmt.Sprintf("%s.%d", store.Project(), i) {
+						errs <- fmt.Errorf("%s: unexpected %s", key, value)
+					}
+				}()
+			}
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		for _, store := range stores {
+			envs, err := store.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, 10, envs.Len())
+
+			value, _ := envs.Get("STORE3")
+			assert.Equal(t, fmt.Sprintf("%s.3", store.Project()), value)
+		}
+
+		store := stores[0]
+
+		err := store.Set(ctx, "STORE0", "store1.0.1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.Rename(ctx, "STORE0", "STORE_RENAMED", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, ok, err := store.Get(ctx, "STORE_RENAMED")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, ok)
+		assert.Equal(t, "store1.0.1", value)
+
+		stats, err := store.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, stats, 10)
+
+		pruned, err := store.Prune(ctx, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 1, pruned)
+
+		deleted, err := store.Delete(ctx, "STORE1", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []string{"STORE1"}, deleted)
+
+		_, ok, err = store.Get(ctx, "STORE1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.False(t, ok)
+
+		value, _, err = stores[1].Get(ctx, "STORE1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "store2.1", value)
+	}
+}
@@ -0,0 +1,173 @@
+package commands_test
+
+import (
+	"context"
+	"errors"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTamperSwap(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		envs := []commands.SetEnv{
+			{
+				Db:       db,
+				Key:      "STRIPE_KEY",
+				Value:    "sk_live",
+				IsGlobal: false,
+			},
+			{
+				Db:       db,
+				Key:      "DEBUG_FLAG",
+				Value:    "false",
+				IsGlobal: false,
+			},
+		}
+
+		for _, command := range envs {
+			err = command.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		mvCommand := commands.Mv{
+			Db:       db,
+			Previous: "DEBUG_FLAG",
+			Next:     "DEBUG",
+		}
+
+		err = mvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, _ := kryptos.ENVS.Get("DEBUG")
+		assert.Equal(t, "false", value)
+
+		_, err = db.ExecContext(ctx, `UPDATE environments
+			SET value = (SELECT value FROM environments WHERE key = $1),
+				data_key = (SELECT data_key FROM environments WHERE key = $1),
+				key_id = (SELECT key_id FROM environments WHERE key = $1)
+			WHERE key = $2;`, "STRIPE_KEY", "DEBUG")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kryptos.GetEnvs(ctx, db)
+		assert.ErrorIs(t, err, kryptos.ErrTampered)
+
+		var tamperErr *kryptos.TamperError
+		if assert.True(t, errors.As(err, &tamperErr)) {
+			assert.Equal(t, "DEBUG", tamperErr.Key)
+		}
+	}
+}
+
+func TestTamperStage(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+		defer kryptos.SetStage("")
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		kryptos.SetStage("dev")
+
+		setCommand := commands.SetEnv{
+			Db:       db,
+			Key:      "STAGED_KEY",
+			Value:    "dev_only",
+			IsGlobal: false,
+		}
+
+		err = setCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE environments SET stage = '' WHERE key = $1;", "STAGED_KEY")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kryptos.SetStage("")
+
+		err = kryptos.GetEnvs(ctx, db)
+		assert.ErrorIs(t, err, kryptos.ErrTampered)
+
+		var tamperErr *kryptos.TamperError
+		if assert.True(t, errors.As(err, &tamperErr)) {
+			assert.Equal(t, "STAGED_KEY", tamperErr.Key)
+		}
+	}
+}
+
+func TestTamperWrongKey(t *testing.T) {
+	key, _ := RandomHex(32)
+	otherKey, _ := RandomHex(32)
+
+	keyring := kryptos.Keyring{Current: key, Retired: []string{otherKey}}
+
+	legacyValue, err := legacyEncrypt("legacy", otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = keyring.Open(legacyValue, kryptos.KeyId(key), nil)
+	assert.ErrorIs(t, err, kryptos.ErrWrongKey)
+
+	opened, err := keyring.Open(legacyValue, kryptos.KeyId(otherKey), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "legacy", string(opened))
+	}
+
+	sealed, err := keyring.Seal([]byte("sealed"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip the last hex digit of the authentication tag
+	last := "0"
+	if strings.HasSuffix(sealed, "0") {
+		last = "1"
+	}
+
+	_, err = keyring.Open(sealed[:len(sealed)-1]+last, "", nil)
+	assert.ErrorIs(t, err, kryptos.ErrTampered)
+}
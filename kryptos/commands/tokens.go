@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"skulpture/kryptos/kryptos"
+	"text/tabwriter"
+	"time"
+)
+
+type TokensAdd struct {
+	Db         *sql.DB
+	Name       string
+	IsReadOnly bool
+	IsGlobal   bool
+	View       io.Writer
+}
+
+// Prints a new API token for the project, it is not shown again
+func (command *TokensAdd) Execute(ctx context.Context) error {
+	token, err := kryptos.CreateToken(ctx, command.Db, scopeProject(command.IsGlobal), command.Name, command.IsReadOnly)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(command.View, token)
+
+	return err
+}
+
+type TokensRm struct {
+	Db       *sql.DB
+	Name     string
+	IsGlobal bool
+}
+
+func (command *TokensRm) Execute(ctx context.Context) error {
+	return kryptos.RemoveToken(ctx, command.Db, scopeProject(command.IsGlobal), command.Name)
+}
+
+type TokensLs struct {
+	Db       *sql.DB
+	IsGlobal bool
+	View     io.Writer
+}
+
+func (command *TokensLs) Execute(ctx context.Context) error {
+	w := tabwriter.NewWriter(command.View, 1, 4, 4, ' ', 0)
+
+	fmt.Fprintln(w, "Name\tRead only\tCreated at")
+
+	tokens, err := kryptos.Tokens(ctx, command.Db, scopeProject(command.IsGlobal))
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		fmt.Fprintf(w, "%s\t%t\t%s\n", token.Name, token.IsReadOnly, token.CreatedAt.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"skulpture/kryptos/kryptos"
+	"syscall"
+)
+
+type Watch struct {
+	Db   *sql.DB
+	View io.Writer
+}
+
+// Prints every change to the project as a line of JSON until interrupted
+func (command *Watch) Execute(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	encoder := json.NewEncoder(command.View)
+
+	for change, err := range kryptos.Watch(ctx, kryptos.WithDb(command.Db)) {
+		if err != nil {
+			return err
+		}
+
+		err = encoder.Encode(change)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"skulpture/kryptos/commands"
+	"skulpture/kryptos/kryptos"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type watchedChange struct {
+	Op      string
+	Key     string
+	Next    string
+	Project string
+}
+
+func TestWatchSetMvRm(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		err := kryptos.GetEnvs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		setEnvCommand := commands.SetEnv{
+			Db:    db,
+			Key:   "WATCH0",
+			Value: "WATCH0",
+		}
+
+		err = setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		watched := make(chan watchedChange, 10)
+		watching := make(chan struct{}, 1)
+		go func() {
+			defer func() {
+				watching <- struct{}{}
+			}()
+
+			for change, err := range kryptos.Watch(watchCtx, kryptos.WithDb(db), kryptos.WithPollInterval(10*time.Millisecond)) {
+				if err != nil {
+					t.Error(err)
+
+					return
+				}
+
+				watched <- watchedChange{change.Op, change.Key, change.Next, change.Project}
+			}
+		}()
+
+		// changes made before watching are not replayed
+		time.Sleep(50 * time.Millisecond)
+
+		writes := []interface{ Execute(context.Context) error }{
+			&commands.SetEnv{Db: db, Key: "WATCH0", Value: "WATCH0.1"},
+			&commands.SetEnv{Db: db, Key: "WATCH1", Value: "WATCH1", IsGlobal: true},
+			&commands.Mv{Db: db, Previous: "WATCH0", Next: "WATCH2"},
+			&commands.Prune{Db: db, Offset: 0},
+			&commands.Rm{Db: db, Key: "WATCH2"},
+		}
+
+		for _, write := range writes {
+			err = write.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// other projects are not watched
+		err = kryptos.NewStore(kryptos.WithDb(db), kryptos.WithProject("other"), kryptos.WithKeyring(kryptos.CurrentKeyring())).
+			Set(ctx, "WATCH3", "WATCH3", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []watchedChange{
+			{"set", "WATCH0", "", "test"},
+			{"set", "WATCH1", "", "*"},
+			{"mv", "WATCH0", "WATCH2", "test"},
+			{"prune", "", "", "test"},
+			{"rm", "WATCH2", "", "test"},
+		}
+
+		for _, change := range expected {
+			select {
+			case actual := <-watched:
+				assert.Equal(t, change, actual)
+			case <-watchCtx.Done():
+				t.Fatalf("missing change: %v", change)
+			}
+		}
+
+		select {
+		case actual := <-watched:
+			t.Fatalf("unexpected change: %v", actual)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		cancel()
+		<-watching
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		watchCtx, cancel := context.WithCancel(ctx)
+
+		out := bytes.Buffer{}
+		watchCommand := commands.Watch{
+			Db:   db,
+			View: &out,
+		}
+
+		watching := make(chan error, 1)
+		go func() {
+			watching <- watchCommand.Execute(watchCtx)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+
+		setEnvCommand := commands.SetEnv{
+			Db:    db,
+			Key:   "WATCH1",
+			Value: "WATCH1",
+		}
+
+		err := setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the command polls every second
+		time.Sleep(1500 * time.Millisecond)
+		cancel()
+
+		err = <-watching
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, lines, 1)
+
+		change := kryptos.Change{}
+		err = json.Unmarshal([]byte(lines[0]), &change)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "set", change.Op)
+		assert.Equal(t, "WATCH1", change.Key)
+		assert.Equal(t, "test", change.Project)
+		assert.Equal(t, "test", change.Author)
+	}
+}
+
+func TestWatchRotateRecipientsSetParent(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close := openMemoryDb(t, ctx)
+		defer close()
+
+		setEnvCommand := commands.SetEnv{
+			Db:    db,
+			Key:   "WATCH0",
+			Value: "WATCH0",
+		}
+
+		err := setEnvCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		watched := make(chan watchedChange, 10)
+		watching := make(chan struct{}, 1)
+		go func() {
+			defer func() {
+				watching <- struct{}{}
+			}()
+
+			for change, err := range kryptos.Watch(watchCtx, kryptos.WithDb(db), kryptos.WithPollInterval(10*time.Millisecond)) {
+				if err != nil {
+					t.Error(err)
+
+					return
+				}
+
+				watched <- watchedChange{change.Op, change.Key, change.Next, change.Project}
+			}
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+
+		identity, publicKey, err := kryptos.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		kryptos.AddIdentity(identity)
+
+		encryptionKey, _ := RandomHex(32)
+
+		writes := []interface{ Execute(context.Context) error }{
+			&commands.Rotate{Db: db, EncryptionKey: encryptionKey, BatchSize: 100, View: &bytes.Buffer{}},
+			&commands.RecipientsAdd{Db: db, Name: "ops", PublicKey: publicKey, View: &bytes.Buffer{}},
+			&commands.RecipientsRm{Db: db, Name: "ops", View: &bytes.Buffer{}},
+			&commands.ProjectSetParent{Db: db, Project: "test", Parent: "base"},
+		}
+
+		for _, write := range writes {
+			err = write.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected := []watchedChange{
+			{"rotate", "", "", "*"},
+			{"recipients", "", "", "test"},
+			{"recipients", "", "", "test"},
+			{"set-parent", "", "base", "test"},
+		}
+
+		for _, change := range expected {
+			select {
+			case actual := <-watched:
+				assert.Equal(t, change, actual)
+			case <-watchCtx.Done():
+				t.Fatalf("missing change: %v", change)
+			}
+		}
+
+		cancel()
+		<-watching
+	}
+}
+
+func TestWatchPruneTrimsChanges(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	for driver, init := range DBs {
+		t.Logf("database: %s", driver)
+
+		stop := init(t)
+		defer stop()
+
+		db, close, err := kryptos.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close()
+
+		for _, value := range []string{"WATCH0", "WATCH0.1"} {
+			setEnvCommand := commands.SetEnv{
+				Db:    db,
+				Key:   "WATCH0",
+				Value: value,
+			}
+
+			err = setEnvCommand.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE changes SET created_at = $1;", time.Now().UTC().Add(-kryptos.CHANGES_RETENTION-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pruneCommand := commands.Prune{
+			Db:     db,
+			Offset: 0,
+		}
+
+		err = pruneCommand.Execute(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the newest expired change is kept so sequences keep increasing, then the prune itself
+		var sequences []int64
+		rows, err := db.QueryContext(ctx, "SELECT sequence FROM changes ORDER BY sequence;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sequence int64
+			err = rows.Scan(&sequence)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sequences = append(sequences, sequence)
+		}
+
+		assert.Equal(t, []int64{2, 3}, sequences)
+	}
+}
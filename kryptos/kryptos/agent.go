@@ -0,0 +1,165 @@
+package kryptos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+var ErrAgentNotRunning = errors.New("kryptos agent is not running")
+
+var agentSocketOverride = ""
+
+// SetAgentSocket takes precedence over AGENT_SOCKET
+func SetAgentSocket(socket string) {
+	agentSocketOverride = socket
+}
+
+// AgentSocket is the unix socket of kryptos agent, agent.sock in the user cache directory unless AGENT_SOCKET is set
+func AgentSocket() string {
+	if agentSocketOverride != "" {
+		return agentSocketOverride
+	}
+
+	socket, ok := AGENT_SOCKET.Value()
+	if ok {
+		return socket
+	}
+
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		cacheDirectory = os.TempDir()
+	}
+
+	return filepath.Join(cacheDirectory, "kryptos", "agent.sock")
+}
+
+// Database identifies the database values are read from, the agent only answers clients of the same database
+func Database() string {
+	connectionString, _ := DB_CONNECTION_STRING.Value()
+
+	sum := sha256.Sum256([]byte(DB_DRIVER.Value() + ":" + connectionString))
+
+	return fmt.Sprintf("sha256:%x", sum)
+}
+
+func agentClient() *http.Client {
+	socket := AgentSocket()
+
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func agentDo(ctx context.Context, method string, path string, query url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://agent%s?%s", path, query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := agentClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAgentNotRunning, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		failure := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&failure)
+
+		return fmt.Errorf("kryptos agent: %s %s", res.Status, failure.Error)
+	}
+
+	if out != nil {
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+
+	return nil
+}
+
+// IsAgentRunning is true when an agent answers on AgentSocket
+func IsAgentRunning() bool {
+	conn, err := net.DialTimeout("unix", AgentSocket(), time.Second)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
+// agentEnv is a value served by the agent with the project it resolves from
+type agentEnv struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Project string `json:"project"`
+}
+
+// agentEnvs reads the values of a project and stage from the agent with the project each resolves from,
+// false when there is no agent to ask
+func agentEnvs(ctx context.Context, project string, stage string) (*orderedmap.OrderedMap[string, string], map[string]string, bool) {
+	if IsRemote() {
+		return nil, nil, false
+	}
+
+	query := url.Values{}
+	query.Set("project", project)
+	query.Set("stage", stage)
+	query.Set("database", Database())
+
+	response := struct {
+		Envs []agentEnv `json:"envs"`
+	}{}
+	err := agentDo(ctx, http.MethodGet, "/v1/envs", query, &response)
+	if err != nil {
+		if isDebug(ctx) && !errors.Is(err, ErrAgentNotRunning) {
+			slog.InfoContext(ctx, "agent", "error", err)
+		}
+
+		return nil, nil, false
+	}
+
+	envs := orderedmap.NewOrderedMap[string, string]()
+	sources := map[string]string{}
+	for _, env := range response.Envs {
+		envs.Set(env.Key, env.Value)
+		sources[env.Key] = env.Project
+	}
+
+	return envs, sources, true
+}
+
+// forgetAgent drops the values cached by the agent after a write, there may be no agent to tell
+func forgetAgent(ctx context.Context) {
+	if IsRemote() {
+		return
+	}
+
+	agentDo(ctx, http.MethodPost, "/v1/forget", url.Values{}, nil)
+}
+
+// LockAgent makes the agent forget every value and exit
+func LockAgent(ctx context.Context) error {
+	return agentDo(ctx, http.MethodPost, "/v1/lock", url.Values{}, nil)
+}
@@ -0,0 +1,190 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+// REASON_HEADER carries --reason to kryptos serve
+const REASON_HEADER = "Kryptos-Reason"
+
+var ContextKeyReason = contextKey("reason")
+
+// Outcomes of an audited operation. An operation is recorded as started before it runs,
+// then with how it ended, denied when a grant or token did not allow it
+const (
+	AUDIT_OUTCOME_STARTED = "started"
+	AUDIT_OUTCOME_OK      = "ok"
+	AUDIT_OUTCOME_DENIED  = "denied"
+	AUDIT_OUTCOME_FAILED  = "failed"
+)
+
+// AuditEntry is one row of audit_log, op is the command such as grep or tokens add.
+// Error is why it was denied or failed
+type AuditEntry struct {
+	Sequence  int64     `json:"sequence"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Project   string    `json:"project"`
+	Stage     string    `json:"stage"`
+	Actor     string    `json:"actor"`
+	Host      string    `json:"host"`
+	Reason    string    `json:"reason,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows AuditLog, a project of * matches every project and Key is a pattern such as DB_*
+type AuditFilter struct {
+	Project string
+	Since   time.Time
+	Key     string
+	Actor   string
+}
+
+// Actor is who audit entries are recorded against, PRINCIPAL when it is set
+func Actor() string {
+	if principal := Principal(); principal != "" {
+		return principal
+	}
+
+	return Author()
+}
+
+func reason(ctx context.Context) string {
+	reason, _ := ctx.Value(ContextKeyReason).(string)
+
+	return reason
+}
+
+// AuditOutcome is the outcome of an operation that returned err
+func AuditOutcome(err error) string {
+	switch {
+	case err == nil:
+		return AUDIT_OUTCOME_OK
+	case errors.Is(err, ErrPermissionDenied):
+		return AUDIT_OUTCOME_DENIED
+	default:
+		return AUDIT_OUTCOME_FAILED
+	}
+}
+
+// Audit records an operation on the project as started, it is called before the operation runs
+// and the operation must not run when it fails. With DB_DRIVER=http the server records the requests instead
+func Audit(ctx context.Context, db *sql.DB, op string, key string) error {
+	return recordOperation(ctx, db, op, key, AUDIT_OUTCOME_STARTED, nil)
+}
+
+// AuditResult records the outcome of an operation once it has run with the error it returned
+func AuditResult(ctx context.Context, db *sql.DB, op string, key string, opErr error) error {
+	return recordOperation(ctx, db, op, key, AuditOutcome(opErr), opErr)
+}
+
+func recordOperation(ctx context.Context, db *sql.DB, op string, key string, outcome string, opErr error) error {
+	if IsRemote() {
+		return nil
+	}
+
+	entry := AuditEntry{
+		Op:      op,
+		Key:     key,
+		Project: Project(),
+		Stage:   Stage(),
+		Actor:   Actor(),
+		Outcome: outcome,
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	return RecordAudit(ctx, db, entry)
+}
+
+// RecordAudit appends an entry to audit_log, the host, reason, outcome and time are filled in when missing
+func RecordAudit(ctx context.Context, db queryer, entry AuditEntry) error {
+	isDebugEnabled := isDebug(ctx)
+
+	if entry.Host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		entry.Host = host
+	}
+
+	if entry.Reason == "" {
+		entry.Reason = reason(ctx)
+	}
+
+	if entry.Outcome == "" {
+		entry.Outcome = AUDIT_OUTCOME_OK
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := db.ExecContext(ctx, `INSERT INTO audit_log(op, key, project, stage, actor, host, reason, outcome, error, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`,
+		entry.Op, entry.Key, entry.Project, entry.Stage, entry.Actor, entry.Host, entry.Reason, entry.Outcome, entry.Error, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("audit %s: %w", entry.Op, err)
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "audit", "op", entry.Op, "key", entry.Key, "project", entry.Project, "actor", entry.Actor, "reason", entry.Reason, "outcome", entry.Outcome)
+	}
+
+	return nil
+}
+
+// AuditLog lists the entries matching the filter oldest first, with PRINCIPAL set it needs admin on the project
+func AuditLog(ctx context.Context, db *sql.DB, filter AuditFilter) ([]AuditEntry, error) {
+	_, err := path.Match(filter.Key, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filter.Key)
+	}
+
+	err = authorizeAll(ctx, db, PERMISSION_ADMIN, filter.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT sequence, op, key, project, stage, actor, host, reason, outcome, error, created_at
+		FROM audit_log
+		WHERE ($1 = '*' OR project = $1) AND created_at >= $2 AND ($3 = '' OR actor = $3)
+		ORDER BY sequence;`, filter.Project, filter.Since.UTC(), filter.Actor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt sql.NullTime
+		err = rows.Scan(&entry.Sequence, &entry.Op, &entry.Key, &entry.Project, &entry.Stage, &entry.Actor, &entry.Host, &entry.Reason, &entry.Outcome, &entry.Error, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.CreatedAt = createdAt.Time
+
+		if filter.Key != "" {
+			isMatch, _ := path.Match(filter.Key, entry.Key)
+			if !isMatch {
+				continue
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
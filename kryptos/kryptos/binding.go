@@ -0,0 +1,240 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+var ErrTampered = errors.New("ciphertext failed authentication")
+
+// TamperError reports a value that does not belong to the row it was read from,
+// such as a ciphertext copied from another key, project or version
+type TamperError struct {
+	Uuid    string
+	Key     string
+	Project string
+	Stage   string
+}
+
+func (err *TamperError) Error() string {
+	return fmt.Sprintf("%s in project %s stage %q (%s) failed authentication and may have been tampered with", err.Key, err.Project, err.Stage, err.Uuid)
+}
+
+func (err *TamperError) Unwrap() error {
+	return ErrTampered
+}
+
+// isBindingRequired is read from kryptos_meta by Open for CurrentKeyring
+var isBindingRequired = false
+
+// binding is the row a value is sealed to, it is authenticated as associated data
+type binding struct {
+	Uuid    string
+	Key     string
+	Project string
+	Stage   string
+}
+
+// additionalData is what a ciphertext of the version was bound to, the stage is bound from version 3
+func (bound binding) additionalData(version int) []byte {
+	fields := []string{"kryptos", bound.Uuid, bound.Key, bound.Project}
+	if version >= 3 {
+		fields = append(fields, bound.Stage)
+	}
+
+	return []byte(strings.Join(fields, "\x00"))
+}
+
+func (bound binding) tampered() error {
+	return &TamperError{
+		Uuid:    bound.Uuid,
+		Key:     bound.Key,
+		Project: bound.Project,
+		Stage:   bound.Stage,
+	}
+}
+
+// rebindEnvelope seals the value to the next row with the same data key, legacy rows are sealed into an envelope
+func rebindEnvelope(ctx context.Context, sealed envelope, keyring Keyring, recipients []Recipient, next binding) (envelope, error) {
+	plain, err := openEnvelope(ctx, sealed, keyring)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	if sealed.DataKey == "" {
+		return sealEnvelope(ctx, plain, keyring, recipients, next)
+	}
+
+	dataKey, err := openDataKey(ctx, sealed, keyring)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	value, err := Keyring{Current: hex.EncodeToString(dataKey)}.Seal([]byte(plain), next.additionalData(CIPHERTEXT_VERSION))
+	if err != nil {
+		return envelope{}, err
+	}
+
+	return envelope{
+		Value:   value,
+		DataKey: sealed.DataKey,
+		KeyId:   sealed.KeyId,
+		Binding: next,
+	}, nil
+}
+
+const (
+	// META_BOUND_VALUES is the ciphertext version every value is bound with, binding is required once it is current
+	META_BOUND_VALUES = "bound_values"
+	// META_BIND_PENDING is set by the migrations that change what a value is bound to
+	META_BIND_PENDING = "bind_pending"
+)
+
+// isBound tells whether every value is bound with the current ciphertext version
+func isBound(ctx context.Context, db queryer) (bool, error) {
+	bound, _, err := getMeta(ctx, db, META_BOUND_VALUES)
+
+	return bound == strconv.Itoa(CIPHERTEXT_VERSION), err
+}
+
+// bindPendingValues runs BindValues after a migration asks for it, as soon as there is a key to do it with.
+// Values the keyring cannot open keep their previous binding and the request stays for the next client,
+// binding is only required once every value is bound
+func bindPendingValues(ctx context.Context, db *sql.DB) error {
+	_, isPending, err := getMeta(ctx, db, META_BIND_PENDING)
+	if err != nil {
+		return err
+	}
+
+	keyring := CurrentKeyring()
+	hasKey := keyring.Current != "" || len(keyring.Identities) > 0 || keyring.Provider != nil
+
+	if isPending && hasKey {
+		err = BindValues(ctx, db)
+		if err != nil {
+			return err
+		}
+	}
+
+	isBindingRequired, err = isBound(ctx, db)
+
+	return err
+}
+
+// BindValues seals every value not bound with the current ciphertext version to its row.
+// Values this keyring cannot open are left for another client, binding stays pending until none are left
+func BindValues(ctx context.Context, db *sql.DB) error {
+	isDebugEnabled := isDebug(ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unbound, err := boundRows(ctx, tx, "SELECT uuid, key, project, stage, value, data_key, key_id FROM environments WHERE value NOT LIKE $1 ORDER BY uuid;",
+		fmt.Sprintf("v%d:%%", CIPHERTEXT_VERSION))
+	if err != nil {
+		return err
+	}
+
+	// the values are being bound, they cannot be required to be already
+	keyring := CurrentKeyring()
+	keyring.IsBindingRequired = false
+
+	skipped, err := rebind(ctx, tx, keyring, unbound, func(bound binding) binding {
+		return bound
+	})
+	if err != nil {
+		return err
+	}
+
+	if skipped == 0 {
+		err = setMeta(ctx, tx, META_BOUND_VALUES, strconv.Itoa(CIPHERTEXT_VERSION))
+		if err != nil {
+			return err
+		}
+
+		err = deleteMeta(ctx, tx, META_BIND_PENDING)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "bind", "values", len(unbound)-skipped, "skipped", skipped)
+	}
+
+	return nil
+}
+
+// boundRows reads uuid, key, project, stage, value, data_key and key_id into envelopes
+func boundRows(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]envelope, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sealed := []envelope{}
+	for rows.Next() {
+		var row envelope
+		err = rows.Scan(&row.Binding.Uuid, &row.Binding.Key, &row.Binding.Project, &row.Binding.Stage, &row.Value, &row.DataKey, &row.KeyId)
+		if err != nil {
+			return nil, err
+		}
+
+		sealed = append(sealed, row)
+	}
+
+	return sealed, rows.Err()
+}
+
+// rebind seals each value to the row next returns for it, values without a key or identity to open them are skipped
+func rebind(ctx context.Context, tx *sql.Tx, keyring Keyring, sealed []envelope, next func(binding) binding) (int, error) {
+	recipients := map[string][]Recipient{}
+	skipped := 0
+
+	for _, row := range sealed {
+		bound := next(row.Binding)
+
+		_, ok := recipients[bound.Project]
+		if !ok {
+			projectRecipients, err := Recipients(ctx, tx, bound.Project)
+			if err != nil {
+				return skipped, err
+			}
+
+			recipients[bound.Project] = projectRecipients
+		}
+
+		rebound, err := rebindEnvelope(ctx, row, keyring, recipients[bound.Project], bound)
+		if errors.Is(err, ErrNoIdentity) || errors.Is(err, ErrNoEncryptionKey) || errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrWrongKey) {
+			skipped++
+
+			continue
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %w", row.Binding.Uuid, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE environments SET value = $1, data_key = $2, key_id = $3 WHERE uuid = $4;",
+			rebound.Value, rebound.DataKey, rebound.KeyId, row.Binding.Uuid)
+		if err != nil {
+			return skipped, err
+		}
+	}
+
+	return skipped, nil
+}
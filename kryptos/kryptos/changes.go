@@ -0,0 +1,266 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const CHANGES_CHANNEL = "kryptos_changes"
+
+// Change is one write to the store, op is set, rm, mv, prune, rotate, recipients or set-parent. Next is the new key of a mv,
+// the new project when Key is empty, or the parent set by set-parent. A rotate is recorded against * for every project
+type Change struct {
+	Sequence  int64     `json:"sequence"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Next      string    `json:"next,omitempty"`
+	Project   string    `json:"project"`
+	Stage     string    `json:"stage"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordChange is written in the transaction of the change so a watcher never sees a change that was rolled back,
+// it must be the last statement before the commit
+func (store *Store) recordChange(ctx context.Context, tx *sql.Tx, op string, key string, next string, project string, stage string) error {
+	// sqlite serializes writers, on pgx the lock makes changes commit in the order of their sequence so
+	// a watcher reading after the last sequence it saw cannot miss one
+	if store.driver == "pgx" {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1));", CHANGES_CHANNEL)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO changes(op, key, next, project, stage, author, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7);`,
+		op, key, next, project, stage, store.author, time.Now().UTC())
+
+	return err
+}
+
+// CHANGES_RETENTION is how long changes are kept for watchers, prune trims older ones
+const CHANGES_RETENTION = 7 * 24 * time.Hour
+
+// trimChanges removes the changes past CHANGES_RETENTION. The newest change is kept because SQLite
+// numbers a new row after the largest one left, a watcher would miss changes numbered again
+func trimChanges(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM changes
+		WHERE created_at < $1 AND sequence < (SELECT MAX(sequence) FROM changes);`, time.Now().UTC().Add(-CHANGES_RETENTION))
+
+	return err
+}
+
+// Watch is Store.Watch configured from the environment like Load, options take precedence
+func Watch(ctx context.Context, options ...StoreOption) iter.Seq2[Change, error] {
+	connectionString, _ := DB_CONNECTION_STRING.Value()
+
+	store := NewStore(append([]StoreOption{
+		WithDriver(DB_DRIVER.Value()),
+		WithConnectionString(connectionString),
+		WithProject(Project()),
+		WithStage(Stage()),
+	}, options...)...)
+
+	return store.Watch(ctx)
+}
+
+// Watch yields the changes made after it is called that affect the project and stage, including the
+// projects they inherit from, until ctx is done or an error is yielded. A store for * sees every change.
+// On pgx changes are pushed with LISTEN/NOTIFY, otherwise the changes table is polled
+func (store *Store) Watch(ctx context.Context) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		if store.db == nil {
+			if store.connectionString == "" {
+				yield(Change{}, fmt.Errorf("%w: %s is required", ErrNotConfigured, DB_CONNECTION_STRING_ENV))
+
+				return
+			}
+
+			db, err := sql.Open(store.driver, store.connectionString)
+			if err != nil {
+				yield(Change{}, err)
+
+				return
+			}
+			defer db.Close()
+
+			store = NewStore(WithDb(db), WithDriver(store.driver), WithProject(store.project), WithStage(store.stage), WithPollInterval(store.pollInterval))
+		}
+
+		var last int64
+		err := store.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(sequence), 0) FROM changes;").Scan(&last)
+		if err != nil {
+			yield(Change{}, err)
+
+			return
+		}
+
+		wake, err := store.listen(ctx)
+		if err != nil {
+			yield(Change{}, err)
+
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-wake:
+				if err != nil {
+					yield(Change{}, err)
+
+					return
+				}
+			}
+
+			changes, next, err := store.changesAfter(ctx, last)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				yield(Change{}, err)
+
+				return
+			}
+
+			for _, change := range changes {
+				if !yield(change, nil) {
+					return
+				}
+			}
+
+			last = next
+		}
+	}
+}
+
+// listen sends nil when there may be new changes and an error when it can no longer tell
+func (store *Store) listen(ctx context.Context) (<-chan error, error) {
+	wake := make(chan error, 1)
+
+	if store.driver != "pgx" || store.connectionString == "" {
+		interval := store.pollInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					select {
+					case wake <- nil:
+					default:
+					}
+				}
+			}
+		}()
+
+		return wake, nil
+	}
+
+	// LISTEN holds the connection, it is not taken from the pool of the store
+	conn, err := pgx.Connect(ctx, store.connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+CHANGES_CHANNEL)
+	if err != nil {
+		conn.Close(context.Background())
+
+		return nil, err
+	}
+
+	go func() {
+		defer conn.Close(context.Background())
+
+		for {
+			notification, err := conn.WaitForNotification(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				select {
+				case wake <- err:
+				case <-ctx.Done():
+				}
+
+				return
+			}
+
+			if isDebug(ctx) {
+				slog.InfoContext(ctx, "notify", "channel", notification.Channel, "sequence", notification.Payload)
+			}
+
+			select {
+			case wake <- nil:
+			default:
+			}
+		}
+	}()
+
+	return wake, nil
+}
+
+// changesAfter reads the changes after sequence that are visible to the store and the last sequence read
+func (store *Store) changesAfter(ctx context.Context, sequence int64) ([]Change, int64, error) {
+	visible := []scope{}
+	if store.project != "*" {
+		var err error
+		visible, err = scopes(ctx, store.db, store.project, store.stage)
+		if err != nil {
+			return nil, sequence, err
+		}
+	}
+
+	rows, err := store.db.QueryContext(ctx, `SELECT sequence, op, key, next, project, stage, author, created_at
+		FROM changes
+		WHERE sequence > $1
+		ORDER BY sequence;`, sequence)
+	if err != nil {
+		return nil, sequence, err
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var change Change
+		var createdAt sql.NullTime
+		err = rows.Scan(&change.Sequence, &change.Op, &change.Key, &change.Next, &change.Project, &change.Stage, &change.Author, &createdAt)
+		if err != nil {
+			return nil, sequence, err
+		}
+		change.CreatedAt = createdAt.Time
+		sequence = change.Sequence
+
+		isProjectMv := change.Op == "mv" && change.Key == ""
+		isVisible := store.project == "*" ||
+			slices.Contains(visible, scope{Project: change.Project, Stage: change.Stage}) ||
+			(isProjectMv && (change.Project == store.project || change.Next == store.project))
+
+		if isVisible {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, sequence, rows.Err()
+}
@@ -0,0 +1,403 @@
+package kryptos
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// envelope is a value sealed with its own data key, the data key is wrapped by the encryption key
+// or sealed to the project recipients. Rows written before envelope encryption have no data key
+// and are sealed by the encryption key
+type envelope struct {
+	Value   string
+	DataKey string
+	KeyId   string
+	Binding binding
+}
+
+func sealEnvelope(ctx context.Context, plain string, keyring Keyring, recipients []Recipient, bound binding) (envelope, error) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	value, err := Keyring{Current: hex.EncodeToString(dataKey)}.Seal([]byte(plain), bound.additionalData(CIPHERTEXT_VERSION))
+	if err != nil {
+		return envelope{}, err
+	}
+
+	sealed, err := wrapDataKey(ctx, value, dataKey, keyring, recipients)
+	sealed.Binding = bound
+
+	return sealed, err
+}
+
+func wrapDataKey(ctx context.Context, value string, dataKey []byte, keyring Keyring, recipients []Recipient) (envelope, error) {
+	if len(recipients) > 0 {
+		wrapped, err := sealToRecipients(dataKey, recipients)
+		if err != nil {
+			return envelope{}, err
+		}
+
+		return envelope{
+			Value:   value,
+			DataKey: wrapped,
+			KeyId:   KEY_ID_RECIPIENTS,
+		}, nil
+	}
+
+	if keyring.Provider != nil {
+		wrapped, err := keyring.Provider.Wrap(ctx, dataKey)
+		if err != nil {
+			return envelope{}, err
+		}
+
+		return envelope{
+			Value:   value,
+			DataKey: wrapped,
+			KeyId:   keyring.Provider.KeyId(),
+		}, nil
+	}
+
+	wrapped, err := keyring.Seal(dataKey, nil)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	return envelope{
+		Value:   value,
+		DataKey: wrapped,
+		KeyId:   KeyId(keyring.Current),
+	}, nil
+}
+
+func openDataKey(ctx context.Context, sealed envelope, keyring Keyring) ([]byte, error) {
+	if sealed.KeyId == KEY_ID_RECIPIENTS {
+		return keyring.openRecipients(sealed.DataKey)
+	}
+
+	if keyring.Provider != nil && sealed.KeyId == keyring.Provider.KeyId() {
+		return keyring.Provider.Unwrap(ctx, sealed.DataKey)
+	}
+
+	return keyring.Open(sealed.DataKey, sealed.KeyId, nil)
+}
+
+func openEnvelope(ctx context.Context, sealed envelope, keyring Keyring) (string, error) {
+	value, err := parseCiphertext(sealed.Value)
+	if err != nil {
+		return "", err
+	}
+
+	if keyring.IsBindingRequired && value.Version < CIPHERTEXT_VERSION {
+		return "", sealed.Binding.tampered()
+	}
+
+	var decrypted []byte
+	if sealed.DataKey == "" {
+		decrypted, err = keyring.Open(sealed.Value, "", nil)
+	} else {
+		var dataKey []byte
+		dataKey, err = openDataKey(ctx, sealed, keyring)
+		if err != nil {
+			return "", err
+		}
+
+		decrypted, err = Keyring{Current: hex.EncodeToString(dataKey)}.Open(sealed.Value, "", sealed.Binding.additionalData(value.Version))
+	}
+	if errors.Is(err, ErrTampered) {
+		return "", sealed.Binding.tampered()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(decrypted), nil
+}
+
+// rewrapEnvelope wraps the data key with the next keyring or recipients, legacy rows are sealed into an envelope
+func rewrapEnvelope(ctx context.Context, sealed envelope, keyring Keyring, next Keyring, recipients []Recipient) (envelope, error) {
+	if sealed.DataKey == "" {
+		plain, err := openEnvelope(ctx, sealed, keyring)
+		if err != nil {
+			return envelope{}, err
+		}
+
+		return sealEnvelope(ctx, plain, next, recipients, sealed.Binding)
+	}
+
+	dataKey, err := openDataKey(ctx, sealed, keyring)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	rewrapped, err := wrapDataKey(ctx, sealed.Value, dataKey, next, recipients)
+	rewrapped.Binding = sealed.Binding
+
+	return rewrapped, err
+}
+
+// rekeyEnvelope seals the value again with a fresh data key, a data key unwrapped before no longer opens it
+func rekeyEnvelope(ctx context.Context, sealed envelope, keyring Keyring, recipients []Recipient) (envelope, error) {
+	plain, err := openEnvelope(ctx, sealed, keyring)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	return sealEnvelope(ctx, plain, keyring, recipients, sealed.Binding)
+}
+
+// ErrRotationIncomplete is returned when a rotation stops with rows left on a previous key
+var ErrRotationIncomplete = errors.New("rotation incomplete")
+
+// RotateReport summarises a rotation, Rotated counts rows that would be rotated on a dry run.
+// Remaining counts the rows still on a previous key once the rotation has stopped
+type RotateReport struct {
+	KeyId     string
+	Rotated   int
+	Verified  int
+	Remaining int
+	IsDryRun  bool
+}
+
+// RotateKey re-wraps every data key across all projects with the next encryption key in batches,
+// data keys sealed to recipients are left as they are. Each batch is committed on its own and rows already wrapped by the next key
+// are skipped, so an interrupted rotation resumes where it stopped when run again and ErrRotationIncomplete reports the rows left.
+// Every row is then verified to open with only the next key before it becomes the encryption key of this process,
+// other clients need ENCRYPTION_KEY set to it
+func RotateKey(ctx context.Context, db *sql.DB, next string, batchSize int, isDryRun bool) (RotateReport, error) {
+	err := authorizeAll(ctx, db, PERMISSION_ROTATE, "*")
+	if err != nil {
+		return RotateReport{}, err
+	}
+
+	report, err := defaultStore(db).rotate(ctx, next, batchSize, isDryRun)
+	if err != nil || isDryRun {
+		return report, err
+	}
+
+	SetEncryptionKey(next)
+
+	return report, nil
+}
+
+// rotate re-wraps with the keyring of the store, the caller holds the lock of the store
+func (store *Store) rotate(ctx context.Context, next string, batchSize int, isDryRun bool) (RotateReport, error) {
+	isDebugEnabled := isDebug(ctx)
+	db := store.db
+	keyring := store.keyring
+
+	report := RotateReport{
+		KeyId:    KeyId(next),
+		IsDryRun: isDryRun,
+	}
+
+	// rows wrapped by the provider would be moved onto the raw key while new values keep going to the provider
+	if keyring.Provider != nil {
+		return report, fmt.Errorf("%w: %s", ErrProviderRotation, keyring.Provider.KeyId())
+	}
+
+	err := validateKey(next)
+	if err != nil {
+		return report, err
+	}
+
+	if batchSize < 1 {
+		batchSize = 100
+	}
+
+	after := ""
+
+	for {
+		count, last, err := store.rotateBatch(ctx, next, batchSize, after, isDryRun)
+		if err != nil {
+			return report, incompleteRotation(ctx, db, &report, err)
+		}
+
+		report.Rotated += count
+		after = last
+
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "rotate", "batch", count, "rotated", report.Rotated, "dry-run", isDryRun)
+		}
+
+		if count < batchSize {
+			break
+		}
+	}
+
+	if isDryRun {
+		return report, nil
+	}
+
+	// rows written with a previous key while the rotation ran are left on it
+	report.Remaining, err = remainingRows(ctx, db, report.KeyId)
+	if err != nil {
+		return report, err
+	}
+
+	if report.Remaining > 0 {
+		return report, incompleteRotation(ctx, db, &report, fmt.Errorf("%d rows were written with a previous key during the rotation", report.Remaining))
+	}
+
+	report.Verified, err = verifyKey(ctx, db, next)
+	if err != nil {
+		return report, incompleteRotation(ctx, db, &report, err)
+	}
+
+	return report, nil
+}
+
+// remainingRows counts the rows not wrapped by the key or sealed to recipients
+func remainingRows(ctx context.Context, db *sql.DB, keyId string) (int, error) {
+	var remaining int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM environments WHERE key_id NOT IN ($1, 'recipients');", keyId).Scan(&remaining)
+
+	return remaining, err
+}
+
+// incompleteRotation tells how far a rotation got, batches before err stay committed. Nothing is written on a dry run
+func incompleteRotation(ctx context.Context, db *sql.DB, report *RotateReport, err error) error {
+	if report.IsDryRun {
+		return err
+	}
+
+	remaining, countErr := remainingRows(ctx, db, report.KeyId)
+	if countErr != nil {
+		return errors.Join(err, countErr)
+	}
+	report.Remaining = remaining
+
+	return fmt.Errorf("%w: %d rows rotated to key id %s and %d left on a previous key, run rotate again with the same key to resume: %w",
+		ErrRotationIncomplete, report.Rotated, report.KeyId, report.Remaining, err)
+}
+
+// rotateBatch re-wraps the rows following after, on a dry run the re-wrapped rows are
+// opened with the next key and the transaction is rolled back. A committed batch is a rotate change to every project
+func (store *Store) rotateBatch(ctx context.Context, next string, batchSize int, after string, isDryRun bool) (int, string, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, after, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT uuid, key, project, stage, value, data_key, key_id
+		FROM environments
+		WHERE key_id NOT IN ($1, 'recipients') AND uuid > $2
+		ORDER BY uuid
+		LIMIT $3;`, KeyId(next), after, batchSize)
+	if err != nil {
+		return 0, after, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		uuid   string
+		sealed envelope
+	}
+
+	batch := []row{}
+	for rows.Next() {
+		var id string
+		var sealed envelope
+		err = rows.Scan(&id, &sealed.Binding.Key, &sealed.Binding.Project, &sealed.Binding.Stage, &sealed.Value, &sealed.DataKey, &sealed.KeyId)
+		if err != nil {
+			return 0, after, err
+		}
+		sealed.Binding.Uuid = id
+
+		batch = append(batch, row{uuid: id, sealed: sealed})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, after, err
+	}
+	rows.Close()
+
+	if len(batch) == 0 {
+		return 0, after, nil
+	}
+
+	update, err := tx.PrepareContext(ctx, "UPDATE environments SET value = $1, data_key = $2, key_id = $3 WHERE uuid = $4;")
+	if err != nil {
+		return 0, after, err
+	}
+	defer update.Close()
+
+	for _, row := range batch {
+		rewrapped, err := rewrapEnvelope(ctx, row.sealed, store.keyring, Keyring{Current: next}, nil)
+		if err != nil {
+			return 0, after, fmt.Errorf("%s: %w", row.uuid, err)
+		}
+
+		if isDryRun {
+			_, err = openEnvelope(ctx, rewrapped, Keyring{Current: next})
+			if err != nil {
+				return 0, after, fmt.Errorf("%s: %w", row.uuid, err)
+			}
+
+			continue
+		}
+
+		_, err = update.ExecContext(ctx, rewrapped.Value, rewrapped.DataKey, rewrapped.KeyId, row.uuid)
+		if err != nil {
+			return 0, after, err
+		}
+	}
+
+	last := batch[len(batch)-1].uuid
+
+	if isDryRun {
+		return len(batch), last, nil
+	}
+
+	err = store.recordChange(ctx, tx, "rotate", "", "", "*", "")
+	if err != nil {
+		return 0, after, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, after, err
+	}
+
+	return len(batch), last, nil
+}
+
+// verifyKey opens every row not sealed to recipients with only the given key
+func verifyKey(ctx context.Context, db *sql.DB, key string) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT uuid, key, project, stage, value, data_key, key_id FROM environments WHERE key_id != 'recipients' ORDER BY uuid;")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	keyring := Keyring{Current: key}
+	verified := 0
+
+	for rows.Next() {
+		var id string
+		var sealed envelope
+		err = rows.Scan(&id, &sealed.Binding.Key, &sealed.Binding.Project, &sealed.Binding.Stage, &sealed.Value, &sealed.DataKey, &sealed.KeyId)
+		if err != nil {
+			return verified, err
+		}
+		sealed.Binding.Uuid = id
+
+		_, err = openEnvelope(ctx, sealed, keyring)
+		if err != nil {
+			return verified, fmt.Errorf("%s: %w", id, err)
+		}
+
+		verified++
+	}
+
+	return verified, rows.Err()
+}
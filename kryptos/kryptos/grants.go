@@ -0,0 +1,272 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+var (
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrUnknownPermission = errors.New("unknown permission, expected read, write, admin or rotate")
+	ErrGrantNotFound     = errors.New("grant not found")
+)
+
+const (
+	PERMISSION_READ   = "read"
+	PERMISSION_WRITE  = "write"
+	PERMISSION_ADMIN  = "admin"
+	PERMISSION_ROTATE = "rotate"
+)
+
+var permissions = []string{PERMISSION_READ, PERMISSION_WRITE, PERMISSION_ADMIN, PERMISSION_ROTATE}
+
+// Grant allows a principal a permission on the keys of a project matching a pattern such as DB_*.
+// A grant on * covers every project, admin covers every other permission and managing grants
+type Grant struct {
+	Principal  string
+	Permission string
+	Project    string
+	Pattern    string
+	CreatedAt  time.Time
+}
+
+func (grant Grant) String() string {
+	return fmt.Sprintf("%s on %s (%s)", grant.Permission, grant.Project, grant.Pattern)
+}
+
+// allows is true when the grant covers the permission on a key of the project, a key of * asks for every key
+func (grant Grant) allows(permission string, project string, key string) bool {
+	if grant.Permission != permission && grant.Permission != PERMISSION_ADMIN {
+		return false
+	}
+
+	if grant.Project != project && grant.Project != "*" {
+		return false
+	}
+
+	isMatch, _ := path.Match(grant.Pattern, key)
+
+	return isMatch
+}
+
+var principalOverride = ""
+
+// SetPrincipal takes precedence over PRINCIPAL
+func SetPrincipal(principal string) {
+	principalOverride = principal
+}
+
+// Principal is who grants are enforced for, nothing is enforced when PRINCIPAL is not set.
+// Grants are checked by this client, keep the database URL to admins and give everyone else a token for serve
+func Principal() string {
+	if principalOverride != "" {
+		return principalOverride
+	}
+
+	principal, _ := PRINCIPAL.Value()
+
+	return principal
+}
+
+// Grants lists the grants of a principal
+func Grants(ctx context.Context, db queryer, principal string) ([]Grant, error) {
+	rows, err := db.QueryContext(ctx, `SELECT principal, permission, project, pattern, created_at
+		FROM grants
+		WHERE principal = $1
+		ORDER BY project, permission, pattern;`, principal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var grant Grant
+		var createdAt sql.NullTime
+		err = rows.Scan(&grant.Principal, &grant.Permission, &grant.Project, &grant.Pattern, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		grant.CreatedAt = createdAt.Time
+
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// EffectiveGrants are the grants of PRINCIPAL that apply to a project
+func EffectiveGrants(ctx context.Context, db queryer, project string) ([]Grant, error) {
+	grants, err := Grants(ctx, db, Principal())
+	if err != nil {
+		return nil, err
+	}
+
+	effective := []Grant{}
+	for _, grant := range grants {
+		if grant.Project == project || grant.Project == "*" {
+			effective = append(effective, grant)
+		}
+	}
+
+	return effective, nil
+}
+
+// permitted tells whether PRINCIPAL has the permission on a key of a project. Everything is permitted when
+// PRINCIPAL is not set, and with DB_DRIVER=http where the server checks the token instead
+func permitted(ctx context.Context, db queryer, permission string) (func(project string, key string) bool, error) {
+	principal := Principal()
+	if principal == "" || IsRemote() {
+		return func(string, string) bool { return true }, nil
+	}
+
+	grants, err := Grants(ctx, db, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(project string, key string) bool {
+		return slices.ContainsFunc(grants, func(grant Grant) bool {
+			return grant.allows(permission, project, key)
+		})
+	}, nil
+}
+
+// authorize returns ErrPermissionDenied unless PRINCIPAL has the permission on every key
+func authorize(ctx context.Context, db queryer, permission string, project string, keys ...string) error {
+	isPermitted, err := permitted(ctx, db, permission)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !isPermitted(project, key) {
+			return fmt.Errorf("%w: %s needs %s on %s (%s)", ErrPermissionDenied, Principal(), permission, project, key)
+		}
+	}
+
+	return nil
+}
+
+// authorizeAll is authorize for a change to every key of the project
+func authorizeAll(ctx context.Context, db queryer, permission string, project string) error {
+	return authorize(ctx, db, permission, project, "*")
+}
+
+// readable drops the values PRINCIPAL cannot read in the project they resolve from, so a grant on a
+// project does not cover the values it inherits from its ancestors or *. A key without a source is dropped
+func readable(ctx context.Context, db queryer, sources map[string]string, envs *orderedmap.OrderedMap[string, string]) (*orderedmap.OrderedMap[string, string], error) {
+	isPermitted, err := permitted(ctx, db, PERMISSION_READ)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range envs.Keys() {
+		if !isPermitted(sources[key], key) {
+			envs.Delete(key)
+		}
+	}
+
+	return envs, nil
+}
+
+// writeProject is the project a write lands in, see Store.writeScope
+func writeProject(isGlobal bool) string {
+	if isGlobal {
+		return "*"
+	}
+
+	return Project()
+}
+
+// AddGrant creates the principal when it is new, with PRINCIPAL set it needs admin on the project
+func AddGrant(ctx context.Context, db *sql.DB, grant Grant) error {
+	isDebugEnabled := isDebug(ctx)
+
+	if !slices.Contains(permissions, grant.Permission) {
+		return fmt.Errorf("%w: %s", ErrUnknownPermission, grant.Permission)
+	}
+
+	_, err := path.Match(grant.Pattern, "")
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, grant.Pattern)
+	}
+
+	err = authorizeAll(ctx, db, PERMISSION_ADMIN, grant.Project)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO principals(name, created_at)
+		VALUES($1, $2)
+		ON CONFLICT(name) DO NOTHING;`, grant.Principal, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO grants(principal, permission, project, pattern, created_at)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT(principal, permission, project, pattern) DO NOTHING;`,
+		grant.Principal, grant.Permission, grant.Project, grant.Pattern, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "grant", "principal", grant.Principal, "permission", grant.Permission, "project", grant.Project, "pattern", grant.Pattern)
+	}
+
+	return nil
+}
+
+// RemoveGrant needs admin on the project when PRINCIPAL is set, the principal is kept
+func RemoveGrant(ctx context.Context, db *sql.DB, grant Grant) error {
+	isDebugEnabled := isDebug(ctx)
+
+	err := authorizeAll(ctx, db, PERMISSION_ADMIN, grant.Project)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, `DELETE FROM grants
+		WHERE principal = $1 AND permission = $2 AND project = $3 AND pattern = $4;`,
+		grant.Principal, grant.Permission, grant.Project, grant.Pattern)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s %s", ErrGrantNotFound, grant.Principal, grant)
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "revoke", "principal", grant.Principal, "permission", grant.Permission, "project", grant.Project, "pattern", grant.Pattern)
+	}
+
+	return nil
+}
@@ -0,0 +1,289 @@
+package kryptos
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownKey            = errors.New("unknown encryption key")
+	ErrInvalidKey            = errors.New("encryption key must be 32 bytes of hex")
+	ErrUnsupportedCiphertext = errors.New("unsupported ciphertext")
+	ErrNoEncryptionKey       = errors.New("ENCRYPTION_KEY or ENCRYPTION_PASSPHRASE must be set")
+	ErrWrongKey              = errors.New("value was not sealed with this encryption key")
+)
+
+const (
+	CIPHERTEXT_VERSION = 3
+	ALGORITHM_AES_GCM  = "aes-256-gcm"
+	ALGORITHM_X25519   = "x25519"
+)
+
+var encryptionKeyOverride = ""
+var retiredKeysOverride = []string{}
+var identitiesOverride = []string{}
+
+// SetEncryptionKey takes precedence over ENCRYPTION_KEY, used once a rotation completes.
+// The key it replaces is retired so values it sealed can still be read
+func SetEncryptionKey(key string) {
+	previous := EncryptionKey()
+	if previous != "" && previous != key {
+		retiredKeysOverride = append(retiredKeysOverride, previous)
+	}
+
+	encryptionKeyOverride = key
+}
+
+// EncryptionKey returns the key encryption key that wraps data keys
+func EncryptionKey() string {
+	if encryptionKeyOverride != "" {
+		return encryptionKeyOverride
+	}
+
+	key, _ := ENCRYPTION_KEY.Value()
+
+	return key
+}
+
+// AddIdentity opens values sealed to its public key alongside IDENTITY
+func AddIdentity(identity string) {
+	identitiesOverride = append(identitiesOverride, identity)
+}
+
+// KeyId identifies an encryption key without revealing it
+func KeyId(key string) string {
+	decodedKey, _ := hex.DecodeString(key)
+	sum := sha256.Sum256(decodedKey)
+
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+func validateKey(key string) error {
+	decodedKey, err := hex.DecodeString(key)
+	if err != nil || len(decodedKey) != 32 {
+		return ErrInvalidKey
+	}
+
+	return nil
+}
+
+// ciphertext is serialised as v<version>:<algorithm>:<key id>:<hex>.
+// Version 0 is the bare hex written before ciphertexts had a header,
+// version 1 was sealed without associated data
+type ciphertext struct {
+	Version   int
+	Algorithm string
+	KeyId     string
+	Data      string
+}
+
+func parseCiphertext(serialised string) (ciphertext, error) {
+	if !strings.Contains(serialised, ":") {
+		return ciphertext{
+			Version:   0,
+			Algorithm: ALGORITHM_AES_GCM,
+			Data:      serialised,
+		}, nil
+	}
+
+	parts := strings.SplitN(serialised, ":", 4)
+	if len(parts) != 4 {
+		return ciphertext{}, fmt.Errorf("%w: %s", ErrUnsupportedCiphertext, parts[0])
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[0], "v"))
+	if err != nil || !strings.HasPrefix(parts[0], "v") || version < 1 || version > CIPHERTEXT_VERSION {
+		return ciphertext{}, fmt.Errorf("%w: %s", ErrUnsupportedCiphertext, parts[0])
+	}
+
+	return ciphertext{
+		Version:   version,
+		Algorithm: parts[1],
+		KeyId:     parts[2],
+		Data:      parts[3],
+	}, nil
+}
+
+func (c ciphertext) String() string {
+	if c.Version == 0 {
+		return c.Data
+	}
+
+	return fmt.Sprintf("v%d:%s:%s:%s", c.Version, c.Algorithm, c.KeyId, c.Data)
+}
+
+// Keyring seals with the current key and opens with whichever key sealed the ciphertext,
+// identities open data keys sealed to recipients. Data keys are wrapped by the provider when there is one.
+// IsBindingRequired rejects values that are not bound to their row with the current ciphertext version
+type Keyring struct {
+	Current           string
+	Retired           []string
+	Identities        []string
+	Provider          KeyProvider
+	IsBindingRequired bool
+}
+
+// CurrentKeyring is ENCRYPTION_KEY, or the key set by a rotation, with RETIRED_ENCRYPTION_KEYS, IDENTITY and KEY_PROVIDER.
+// Binding is required once Open has found every value bound
+func CurrentKeyring() Keyring {
+	retired := slices.Clone(retiredKeysOverride)
+	identities, _ := IDENTITY.Value()
+
+	configured, _ := RETIRED_ENCRYPTION_KEYS.Value()
+	retired = append(retired, splitKeys(configured)...)
+
+	return Keyring{
+		Current:           EncryptionKey(),
+		Retired:           retired,
+		Identities:        append(splitKeys(identities), identitiesOverride...),
+		Provider:          keyProviderOverride,
+		IsBindingRequired: isBindingRequired,
+	}
+}
+
+func splitKeys(keys string) []string {
+	split := []string{}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			split = append(split, key)
+		}
+	}
+
+	return split
+}
+
+func (keyring Keyring) keys() []string {
+	keys := []string{}
+	for _, key := range append([]string{keyring.Current}, keyring.Retired...) {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (keyring Keyring) key(id string) (string, bool) {
+	for _, key := range keyring.keys() {
+		if KeyId(key) == id {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// processFingerprintKey keys fingerprints when there is no encryption key to derive one from
+var processFingerprintKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return key
+})
+
+// Fingerprint identifies a value without revealing it. It is an HMAC keyed by a key derived from the current key,
+// so guessing a value needs the key and fingerprints only compare under the same key.
+// Without an encryption key they are keyed per process and only compare within one invocation
+func (keyring Keyring) Fingerprint(value string) string {
+	fingerprintKey := processFingerprintKey()
+	if keyring.Current != "" {
+		decodedKey, _ := hex.DecodeString(keyring.Current)
+
+		derive := hmac.New(sha256.New, decodedKey)
+		derive.Write([]byte("kryptos fingerprint"))
+		fingerprintKey = derive.Sum(nil)
+	}
+
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(value))
+
+	return fmt.Sprintf("hmac-sha256:%x", mac.Sum(nil)[:8])
+}
+
+// Seal encrypts with the current key, additionalData is authenticated but not stored
+func (keyring Keyring) Seal(plain []byte, additionalData []byte) (string, error) {
+	if keyring.Current == "" {
+		return "", ErrNoEncryptionKey
+	}
+
+	encrypted, err := encrypt(string(plain), keyring.Current, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return ciphertext{
+		Version:   CIPHERTEXT_VERSION,
+		Algorithm: ALGORITHM_AES_GCM,
+		KeyId:     KeyId(keyring.Current),
+		Data:      encrypted,
+	}.String(), nil
+}
+
+// Open decrypts with the key named in the header, keyId is used for version 0 ciphertexts
+// and when it is empty every key is tried. additionalData only applies from version 2
+func (keyring Keyring) Open(serialised string, keyId string, additionalData []byte) ([]byte, error) {
+	sealed, err := parseCiphertext(serialised)
+	if err != nil {
+		return nil, err
+	}
+
+	if sealed.Version < 2 {
+		additionalData = nil
+	}
+
+	if sealed.Algorithm == ALGORITHM_X25519 {
+		return keyring.openRecipient(sealed)
+	}
+
+	if sealed.Algorithm != ALGORITHM_AES_GCM {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCiphertext, sealed.Algorithm)
+	}
+
+	if len(keyring.keys()) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+
+	if sealed.Version > 0 {
+		keyId = sealed.KeyId
+	}
+
+	if keyId == "" {
+		for _, key := range keyring.keys() {
+			decrypted, err := decrypt(sealed.Data, key, additionalData)
+			if err == nil {
+				return []byte(decrypted), nil
+			}
+		}
+
+		return nil, fmt.Errorf("%w: no key opens this value", ErrUnknownKey)
+	}
+
+	key, ok := keyring.key(keyId)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+
+	decrypted, err := decrypt(sealed.Data, key, additionalData)
+	if errors.Is(err, errUnauthenticated) && sealed.Version == 0 {
+		// a version 0 ciphertext has no header, its key id comes from the row and may not be the key that sealed it
+		return nil, fmt.Errorf("%w: %s", ErrWrongKey, keyId)
+	}
+	if errors.Is(err, errUnauthenticated) {
+		// the header names the key that sealed the ciphertext, so the ciphertext or its row changed
+		return nil, ErrTampered
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(decrypted), nil
+}
@@ -7,11 +7,15 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"runtime"
+	"os/user"
+	"skulpture/kryptos/migrations"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/dogmatiq/ferrite"
 	"github.com/elliotchance/orderedmap/v2"
@@ -19,8 +23,7 @@ import (
 	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/pgx"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/google/uuid"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -28,12 +31,54 @@ import (
 type contextKey string
 
 var (
-	PROJECT_ENV              = "PROJECT"
-	DB_DRIVER_ENV            = "DB_DRIVER"
-	DB_CONNECTION_STRING_ENV = "DB_CONNECTION_STRING"
-	ENCRYPTION_KEY_ENV       = "ENCRYPTION_KEY"
+	PROJECT_ENV                 = "PROJECT"
+	DB_DRIVER_ENV               = "DB_DRIVER"
+	DB_CONNECTION_STRING_ENV    = "DB_CONNECTION_STRING"
+	ENCRYPTION_KEY_ENV          = "ENCRYPTION_KEY"
+	AUTHOR_ENV                  = "AUTHOR"
+	STAGE_ENV                   = "STAGE"
+	RETIRED_ENCRYPTION_KEYS_ENV = "RETIRED_ENCRYPTION_KEYS"
+	ENCRYPTION_PASSPHRASE_ENV   = "ENCRYPTION_PASSPHRASE"
+	IDENTITY_ENV                = "IDENTITY"
+	KEY_PROVIDER_ENV            = "KEY_PROVIDER"
+	KEY_FILE_ENV                = "KEY_FILE"
+	VAULT_ADDR_ENV              = "VAULT_ADDR"
+	VAULT_TOKEN_ENV             = "VAULT_TOKEN"
+	VAULT_TRANSIT_KEY_ENV       = "VAULT_TRANSIT_KEY"
+	GCP_KMS_KEY_ENV             = "GCP_KMS_KEY"
+	GCP_ACCESS_TOKEN_ENV        = "GCP_ACCESS_TOKEN"
+	API_TOKEN_ENV               = "API_TOKEN"
+	PROFILE_FILE_ENV            = "PROFILE_FILE"
+	AGENT_SOCKET_ENV            = "AGENT_SOCKET"
+	PRINCIPAL_ENV               = "PRINCIPAL"
+	ENV_FILE_PATH_ENV           = "ENV_FILE_PATH"
 )
 
+// CONFIG_ENVS configure kryptos itself, run does not pass them on so keys and tokens stay with kryptos
+var CONFIG_ENVS = []string{
+	PROJECT_ENV,
+	DB_DRIVER_ENV,
+	DB_CONNECTION_STRING_ENV,
+	ENCRYPTION_KEY_ENV,
+	AUTHOR_ENV,
+	STAGE_ENV,
+	RETIRED_ENCRYPTION_KEYS_ENV,
+	ENCRYPTION_PASSPHRASE_ENV,
+	IDENTITY_ENV,
+	KEY_PROVIDER_ENV,
+	KEY_FILE_ENV,
+	VAULT_ADDR_ENV,
+	VAULT_TOKEN_ENV,
+	VAULT_TRANSIT_KEY_ENV,
+	GCP_KMS_KEY_ENV,
+	GCP_ACCESS_TOKEN_ENV,
+	API_TOKEN_ENV,
+	PROFILE_FILE_ENV,
+	AGENT_SOCKET_ENV,
+	PRINCIPAL_ENV,
+	ENV_FILE_PATH_ENV,
+}
+
 var (
 	ContextKeyDebug = contextKey("debug")
 	VERSION         = "0.0.1"
@@ -42,150 +87,229 @@ var (
 			WithDefault("*").
 			Required()
 	DB_DRIVER = ferrite.
-			Enum(DB_DRIVER_ENV, "Database driver").
-			WithMembers("sqlite3", "pgx").
+			Enum(DB_DRIVER_ENV, "Database driver, http connects to kryptos serve").
+			WithMembers("sqlite3", "pgx", "http").
 			WithDefault("sqlite3").
 			Required()
 	DB_CONNECTION_STRING = ferrite.
-				String(DB_CONNECTION_STRING_ENV, "Database connection string").
-				Required()
+				String(DB_CONNECTION_STRING_ENV, "Database connection string, or the server URL when DB_DRIVER is http").
+				Optional()
 	ENCRYPTION_KEY = ferrite.
 			String(ENCRYPTION_KEY_ENV, "32 byte encryption key, `openssl rand -hex 32`").
+			Optional()
+	AUTHOR = ferrite.
+		String(AUTHOR_ENV, "Author recorded against changes, defaults to the current user").
+		Optional()
+	STAGE = ferrite.
+		String(STAGE_ENV, "Stage within the project, such as dev, staging or master").
+		Optional()
+	ENCRYPTION_PASSPHRASE = ferrite.
+				String(ENCRYPTION_PASSPHRASE_ENV, "Passphrase the encryption key is derived from when ENCRYPTION_KEY is not set").
+				Optional()
+	IDENTITY = ferrite.
+			String(IDENTITY_ENV, "Comma separated X25519 private keys that open values sealed to recipients").
+			Optional()
+	KEY_PROVIDER = ferrite.
+			Enum(KEY_PROVIDER_ENV, "Wraps data keys, env uses ENCRYPTION_KEY").
+			WithMembers("env", "file", "vault", "gcpkms").
+			WithDefault("env").
 			Required()
+	KEY_FILE = ferrite.
+			String(KEY_FILE_ENV, "File containing a 32 byte hex key, used by the file key provider").
+			Optional()
+	VAULT_ADDR = ferrite.
+			String(VAULT_ADDR_ENV, "Vault address, used by the vault key provider").
+			Optional()
+	VAULT_TOKEN = ferrite.
+			String(VAULT_TOKEN_ENV, "Vault token, used by the vault key provider").
+			Optional()
+	VAULT_TRANSIT_KEY = ferrite.
+				String(VAULT_TRANSIT_KEY_ENV, "Vault transit key name, used by the vault key provider").
+				Optional()
+	GCP_KMS_KEY = ferrite.
+			String(GCP_KMS_KEY_ENV, "Cloud KMS key resource name, used by the gcpkms key provider").
+			Optional()
+	GCP_ACCESS_TOKEN = ferrite.
+				String(GCP_ACCESS_TOKEN_ENV, "OAuth access token, `gcloud auth print-access-token`, used by the gcpkms key provider").
+				Optional()
+	API_TOKEN = ferrite.
+			String(API_TOKEN_ENV, "Token from `kryptos tokens add`, used when DB_DRIVER is http").
+			Optional()
+	PROFILE_FILE = ferrite.
+			String(PROFILE_FILE_ENV, "Dotenv file API_TOKEN is read from when it is not set, defaults to ~/.config/kryptos/profile").
+			Optional()
+	AGENT_SOCKET = ferrite.
+			String(AGENT_SOCKET_ENV, "Unix socket of kryptos agent, defaults to kryptos/agent.sock in the user cache directory").
+			Optional()
+	PRINCIPAL = ferrite.
+			String(PRINCIPAL_ENV, "Principal whose grants are enforced, `kryptos grant`, nothing is enforced when it is not set").
+			Optional()
+	RETIRED_ENCRYPTION_KEYS = ferrite.
+				String(RETIRED_ENCRYPTION_KEYS_ENV, "Comma separated encryption keys that can still open values but no longer seal them").
+				Optional()
 )
 
 var ENVS = orderedmap.NewOrderedMap[string, string]()
 
-type envStat struct {
-	Key     string
-	Project string
-	Count   int
+var (
+	ErrVersionNotFound    = errors.New("version not found")
+	ErrHistoryUnavailable = errors.New("values at that time were removed or renamed since")
+)
+
+var projectOverride = ""
+var stageOverride = ""
+
+// SetProject takes precedence over PROJECT, used by --project. It returns the previous
+// override so a command can restore it, an empty project clears it
+func SetProject(project string) string {
+	previous := projectOverride
+	projectOverride = project
+
+	return previous
 }
 
-func Stats(ctx context.Context, db *sql.DB) ([]envStat, error) {
-	rows, err := db.QueryContext(ctx, `WITH 
-		project_environments AS (SELECT key, project 
-			FROM environments
-			WHERE project = $1),
-		global_environments AS (SELECT key, project
-			FROM environments
-			WHERE project = '*'),
-		scoped_environments AS (SELECT key, project
-			FROM project_environments
-			UNION ALL
-			SELECT key, project
-			FROM global_environments
-			WHERE NOT EXISTS(SELECT * FROM project_environments WHERE project_environments.key = global_environments.key)),
-		counts AS (SELECT key, COUNT(key)
-			FROM scoped_environments
-			GROUP BY key)
-		
-		SELECT DISTINCT counts.key, scoped_environments.project, counts.count FROM counts
-		INNER JOIN scoped_environments
-		ON scoped_environments.key = counts.key
-		ORDER BY counts.key COLLATE NOCASE;
-	`, PROJECT.Value())
-	if err != nil {
-		return nil, err
+// Project returns the project commands operate on
+func Project() string {
+	if projectOverride != "" {
+		return projectOverride
 	}
-	defer rows.Close()
 
-	envs := []envStat{}
+	return PROJECT.Value()
+}
 
-	for rows.Next() {
-		var key string
-		var project string
-		var count int
-		err = rows.Scan(&key, &project, &count)
-		if err != nil {
-			return nil, err
-		}
+// SetStage takes precedence over STAGE, used by --stage. It returns the previous
+// override so a command can restore it, an empty stage clears it
+func SetStage(stage string) string {
+	previous := stageOverride
+	stageOverride = stage
 
-		envStat := envStat{
-			Key:     key,
-			Project: project,
-			Count:   count,
-		}
+	return previous
+}
 
-		envs = append(envs, envStat)
+// Stage returns the stage commands operate on, an empty stage is the project itself
+func Stage() string {
+	if stageOverride != "" {
+		return stageOverride
 	}
 
-	err = rows.Err()
+	stage, _ := STAGE.Value()
+
+	return stage
+}
+
+// ResetOverrides clears every override so values are read from the environment again,
+// such as between tests sharing the process
+func ResetOverrides() {
+	projectOverride = ""
+	stageOverride = ""
+	encryptionKeyOverride = ""
+	retiredKeysOverride = []string{}
+	identitiesOverride = []string{}
+	principalOverride = ""
+	agentSocketOverride = ""
+	keyProviderOverride = nil
+	remoteOverride = nil
+}
+
+type scope struct {
+	Project string
+	Stage   string
+}
+
+// scopes lists where values are resolved from, by precedence: project+stage, project,
+// then each ancestor+stage and ancestor, and finally global
+func scopes(ctx context.Context, db *sql.DB, project string, stage string) ([]scope, error) {
+	chain, err := Ancestors(ctx, db, project)
 	if err != nil {
 		return nil, err
 	}
 
-	return envs, nil
-}
+	candidates := []scope{}
+	for _, project := range append([]string{project}, chain...) {
+		candidates = append(candidates, scope{Project: project, Stage: stage}, scope{Project: project, Stage: ""})
+	}
+	candidates = append(candidates, scope{Project: "*", Stage: ""})
 
-func GetEnvs(ctx context.Context, db *sql.DB) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
-
-	query := `WITH 
-		project_environments AS (SELECT key, value 
-			FROM environments
-			WHERE deprecated = 0 AND project = $1),
-		global_environments AS (SELECT key, value
-			FROM environments
-			WHERE deprecated = 0 AND project = '*'),
-		result AS (SELECT key, value
-			FROM project_environments
-			UNION ALL
-			SELECT key, value
-			FROM global_environments
-			WHERE NOT EXISTS(SELECT * FROM project_environments WHERE project_environments.key = global_environments.key))
-
-		SELECT * FROM result
-		`
+	resolved := []scope{}
+	for _, candidate := range candidates {
+		if !slices.Contains(resolved, candidate) {
+			resolved = append(resolved, candidate)
+		}
+	}
 
-	if DB_DRIVER.Value() == "sqlite3" {
-		queryWithSort := fmt.Sprintf(`%s ORDER BY key COLLATE NOCASE;`, query)
+	return resolved, nil
+}
 
-		query = queryWithSort
-	} else if DB_DRIVER.Value() == "pgx" {
-		queryWithSort := fmt.Sprintf("%s ORDER BY LOWER(key), key;", query)
+// scopesTable renders scopes as a CTE with a precedence column, placeholders start after offset.
+// SQLite numbers placeholders in order of appearance so they have to be used in ascending order
+func scopesTable(scopes []scope, offset int) (string, []any) {
+	values := []string{}
+	args := []any{}
 
-		query = queryWithSort
+	for i, scope := range scopes {
+		values = append(values, fmt.Sprintf("(CAST($%d AS TEXT), CAST($%d AS TEXT), %d)", offset+len(args)+1, offset+len(args)+2, i))
+		args = append(args, scope.Project, scope.Stage)
 	}
 
-	envs, err := db.PrepareContext(ctx, query)
-	if err != nil {
-		return err
-	}
-	defer envs.Close()
+	return fmt.Sprintf("scopes(project, stage, precedence) AS (VALUES %s)", strings.Join(values, ", ")), args
+}
+
+type envStat struct {
+	Key     string
+	Project string
+	Stage   string
+	Count   int
+}
+
+type envVersion struct {
+	Uuid        string
+	Version     int
+	Key         string
+	Project     string
+	Stage       string
+	Author      string
+	CreatedAt   time.Time
+	Fingerprint string
+	Deprecated  bool
+}
 
-	rows, err := envs.QueryContext(ctx, PROJECT.Value())
+// GetEnvs loads the current values into ENVS, from the agent when one is running
+func GetEnvs(ctx context.Context, db *sql.DB) error {
+	envs, err := ResolveEnvs(ctx, db, Project(), Stage())
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	ENVS = orderedmap.NewOrderedMap[string, string]()
-	for rows.Next() {
-		var key string
-		var encrypted string
-		err = rows.Scan(&key, &encrypted)
-		if err != nil {
-			return err
-		}
+	ENVS = envs
 
-		if isDebugEnabled {
-			slog.InfoContext(ctx, "get", "env", key)
-		}
+	return nil
+}
 
-		decrypted, err := decrypt(encrypted, ENCRYPTION_KEY.Value())
+// ResolveEnvs loads the current values of a project and stage without changing ENVS
+func ResolveEnvs(ctx context.Context, db *sql.DB, project string, stage string) (*orderedmap.OrderedMap[string, string], error) {
+	envs, sources, ok := agentEnvs(ctx, project, stage)
+	if !ok {
+		var err error
+		envs, sources, err = listSourcesAsOf(ctx, db, project, stage, time.Time{})
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		if isDebugEnabled {
-			slog.InfoContext(ctx, "decrypt", "env", key)
-		}
+	return readable(ctx, db, sources, envs)
+}
 
-		ENVS.Set(key, decrypted)
+// GetEnvsAsOf loads the values that were current at a point in time, every uuid is a UUIDv7
+// so versions are ordered by creation time. Keys removed by rm are absent after the rm, versions removed
+// by prune are not recovered, and ErrHistoryUnavailable is returned for a time when a value deleted by rm
+// was current or before an mv of the scope
+func GetEnvsAsOf(ctx context.Context, db *sql.DB, asOf time.Time) error {
+	envs, sources, err := listSourcesAsOf(ctx, db, Project(), Stage(), asOf)
+	if err != nil {
+		return err
 	}
 
-	err = rows.Err()
+	ENVS, err = readable(ctx, db, sources, envs)
 	if err != nil {
 		return err
 	}
@@ -193,177 +317,157 @@ func GetEnvs(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-func DeleteEnv(ctx context.Context, db *sql.DB, key string, includeDeprecated bool, includeGlobal bool) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
+// listSourcesAsOf resolves the values with the project each comes from, the server checks its token
+// rather than grants with DB_DRIVER=http so there are no sources to return
+func listSourcesAsOf(ctx context.Context, db *sql.DB, project string, stage string, asOf time.Time) (*orderedmap.OrderedMap[string, string], map[string]string, error) {
+	backend := newBackend(db, project, stage)
 
-	inProjectFilter := ""
-	if includeGlobal {
-		inProjectFilter = "($2, '*')"
-	} else {
-		inProjectFilter = "($2)"
-	}
+	store, ok := backend.(*Store)
+	if !ok {
+		envs, err := backend.ListAsOf(ctx, asOf)
 
-	inDeprecatedFilter := ""
-	if includeDeprecated {
-		inDeprecatedFilter = "(0, 1)"
-	} else {
-		inDeprecatedFilter = "(0)"
+		return envs, map[string]string{}, err
 	}
 
-	statement := fmt.Sprintf(`DELETE FROM environments
-		WHERE key = $1
-		AND project IN %s
-		AND deprecated IN %s
-		RETURNING key;`, inProjectFilter, inDeprecatedFilter)
+	return store.ListSourcesAsOf(ctx, asOf)
+}
+
+// uuidUpperBound is the greatest UUIDv7 that could have been generated at t
+func uuidUpperBound(t time.Time) string {
+	milliseconds := t.UnixMilli()
 
-	deleteEnv, err := db.PrepareContext(ctx, statement)
+	return fmt.Sprintf("%08x-%04x-7fff-bfff-ffffffffffff", milliseconds>>16, milliseconds&0xffff)
+}
+
+func Stats(ctx context.Context, db *sql.DB) ([]envStat, error) {
+	envStats, err := newBackend(db, Project(), Stage()).Stats(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer deleteEnv.Close()
 
-	var rows *sql.Rows
-	rows, err = deleteEnv.QueryContext(ctx, key, PROJECT.Value())
+	isPermitted, err := permitted(ctx, db, PERMISSION_READ)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var key string
-		err = rows.Scan(&key)
-		if err != nil {
-			return err
-		}
 
-		if isDebugEnabled {
-			slog.InfoContext(ctx, "delete", "env", key)
+	readable := []envStat{}
+	for _, envStat := range envStats {
+		if isPermitted(envStat.Project, envStat.Key) {
+			readable = append(readable, envStat)
 		}
-
-		ENVS.Delete(key)
-	}
-
-	if isDebugEnabled {
-		slog.InfoContext(ctx, "delete", "env", key, "project", PROJECT.Value(), "includeGlobal", includeGlobal)
 	}
 
-	return nil
+	return readable, nil
 }
 
-func SetEnv(ctx context.Context, db *sql.DB, key string, value string, isGlobal bool) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
+func History(ctx context.Context, db *sql.DB, key string, isGlobal bool) ([]envVersion, error) {
+	if IsRemote() {
+		return nil, fmt.Errorf("%w: log", ErrRemoteUnsupported)
+	}
 
-	tx, err := db.Begin()
+	err := authorize(ctx, db, PERMISSION_READ, writeProject(isGlobal), key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	deprecate, err := tx.PrepareContext(ctx, "UPDATE environments SET deprecated = 1 WHERE key = $1 AND project = $2;")
+	return defaultStore(db).History(ctx, key, isGlobal)
+}
+
+func DeleteEnv(ctx context.Context, db *sql.DB, key string, includeDeprecated bool, includeGlobal bool) error {
+	err := authorize(ctx, db, PERMISSION_WRITE, Project(), key)
 	if err != nil {
 		return err
 	}
-	defer deprecate.Close()
 
-	var project string
-	if isGlobal {
-		project = "*"
-	} else {
-		project = PROJECT.Value()
+	if includeGlobal {
+		err = authorize(ctx, db, PERMISSION_WRITE, "*", key)
+		if err != nil {
+			return err
+		}
 	}
 
-	result, err := deprecate.ExecContext(ctx, key, project)
+	deleted, err := newBackend(db, Project(), Stage()).Delete(ctx, key, includeDeprecated, includeGlobal)
 	if err != nil {
 		return err
 	}
 
-	if isDebugEnabled {
-		rowsAffected, _ := result.RowsAffected()
+	forgetAgent(ctx)
 
-		slog.InfoContext(ctx, "deprecated", "affected", rowsAffected)
-		slog.InfoContext(ctx, "deprecate", "env", key, "project", PROJECT.Value())
+	for _, key := range deleted {
+		ENVS.Delete(key)
 	}
 
-	insert, err := tx.PrepareContext(ctx, `INSERT INTO environments(uuid, key, value, project, deprecated)
-		VALUES($1, $2, $3, $4, 0);`)
-	if err != nil {
-		return err
+	return nil
+}
+
+func SetEnv(ctx context.Context, db *sql.DB, key string, value string, isGlobal bool) error {
+	if IsRemote() {
+		return setRemoteEnv(ctx, key, value, isGlobal)
 	}
-	defer insert.Close()
 
-	uuid, _ := uuid.NewV7()
-	encrypted, err := encrypt(value, ENCRYPTION_KEY.Value())
+	err := authorize(ctx, db, PERMISSION_WRITE, writeProject(isGlobal), key)
 	if err != nil {
 		return err
 	}
-	result, err = insert.ExecContext(ctx, uuid, key, encrypted, project)
+
+	store := defaultStore(db)
+
+	err = store.Set(ctx, key, value, isGlobal)
 	if err != nil {
 		return err
 	}
 
-	if isDebugEnabled {
-		rowsAffected, _ := result.RowsAffected()
+	forgetAgent(ctx)
 
-		slog.InfoContext(ctx, "insert", "affected", rowsAffected)
-		slog.InfoContext(ctx, "insert", "env", key, "project", PROJECT.Value())
+	project, stage := store.writeScope(isGlobal)
+
+	return applyEnv(ctx, store, key, value, project, stage)
+}
+
+func RollbackEnv(ctx context.Context, db *sql.DB, key string, to string, isGlobal bool) error {
+	if IsRemote() {
+		return fmt.Errorf("%w: rollback", ErrRemoteUnsupported)
 	}
 
-	err = tx.Commit()
+	err := authorize(ctx, db, PERMISSION_WRITE, writeProject(isGlobal), key)
 	if err != nil {
 		return err
 	}
 
-	_, ok := ENVS.Get(key)
-	if project == "*" && PROJECT.Value() != "*" && ok {
-		find, err := db.PrepareContext(ctx, "SELECT uuid FROM environments WHERE key = $1 AND project = $2 AND deprecated = 0;")
-		if err != nil {
-			return err
-		}
-
-		rows, err := find.QueryContext(ctx, key, PROJECT.Value())
-		if err != nil {
-			return err
-		}
+	store := defaultStore(db)
 
-		if rows.Next() {
-			return nil
-		}
+	value, restored, err := store.rollback(ctx, key, to, isGlobal)
+	if err != nil || !restored {
+		return err
 	}
 
-	ENVS.Set(key, value)
-	os.Setenv(key, value)
+	forgetAgent(ctx)
 
-	return nil
+	project, stage := store.writeScope(isGlobal)
+
+	return applyEnv(ctx, store, key, value, project, stage)
 }
 
 func Rename(ctx context.Context, db *sql.DB, previous string, next string, isGlobal bool, isProject bool) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
-
-	projectStatement := "UPDATE environments SET project = $1 WHERE project = $2 AND project != '*';"
-	environmentStatement := "UPDATE environments SET key = $1 WHERE key = $2 AND project = $3;"
-
-	statement := ""
+	var err error
 	if isProject {
-		statement = projectStatement
+		err = authorizeAll(ctx, db, PERMISSION_ADMIN, previous)
+		if err == nil {
+			err = authorizeAll(ctx, db, PERMISSION_ADMIN, next)
+		}
 	} else {
-		statement = environmentStatement
-	}
-
-	project := PROJECT.Value()
-	if isGlobal && !isProject {
-		project = "*"
+		err = authorize(ctx, db, PERMISSION_WRITE, writeProject(isGlobal), previous, next)
 	}
-
-	mv, err := db.PrepareContext(ctx, statement)
 	if err != nil {
 		return err
 	}
-	defer mv.Close()
 
-	rows, err := mv.QueryContext(ctx, next, previous, project)
+	err = newBackend(db, Project(), Stage()).Rename(ctx, previous, next, isGlobal, isProject)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+
+	forgetAgent(ctx)
 
 	if !isProject {
 		value, _ := ENVS.Get(previous)
@@ -373,140 +477,130 @@ func Rename(ctx context.Context, db *sql.DB, previous string, next string, isGlo
 		ENVS.Set(next, value)
 	}
 
-	if isDebugEnabled {
-		slog.InfoContext(ctx, "rename", "previous", previous, "next", next, "isProject", isProject)
-	}
-
 	return nil
 }
 
 func PruneEnv(ctx context.Context, db *sql.DB, offset int, withGlobal bool) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
-
-	prune, err := db.PrepareContext(ctx, `DELETE FROM environments 
-		WHERE uuid 
-		IN (
-			SELECT uuid 
-			FROM environments 
-			WHERE project = $1 AND deprecated = 1
-			ORDER BY uuid DESC
-			LIMIT $2
-			OFFSET $3);`)
+	err := authorizeAll(ctx, db, PERMISSION_WRITE, writeProject(withGlobal))
 	if err != nil {
 		return err
 	}
-	defer prune.Close()
 
-	var project string
-	if withGlobal {
-		project = "*"
-	} else {
-		project = PROJECT.Value()
+	_, err = newBackend(db, Project(), Stage()).Prune(ctx, offset, withGlobal)
+	if err != nil {
+		return err
 	}
 
-	var rows *sql.Rows
-	if DB_DRIVER.Value() == "sqlite3" {
-		rows, err = prune.QueryContext(ctx, project, "-1", offset)
-		if err != nil {
-			return err
-		}
-	} else if DB_DRIVER.Value() == "pgx" {
-		rows, err = prune.QueryContext(ctx, project, nil, offset)
-		if err != nil {
-			return err
-		}
+	forgetAgent(ctx)
+
+	return nil
+}
+
+func ClearEnv(ctx context.Context, db *sql.DB, offset int, withGlobal bool) error {
+	err := authorizeAll(ctx, db, PERMISSION_WRITE, writeProject(withGlobal))
+	if err != nil {
+		return err
 	}
 
-	for rows.Next() {
-		var key string
-		err = rows.Scan(&key)
-		if err != nil {
-			return err
-		}
+	deleted, err := newBackend(db, Project(), Stage()).Clear(ctx, offset, withGlobal)
+	if err != nil {
+		return err
+	}
 
-		if isDebugEnabled {
-			slog.InfoContext(ctx, "prune", "env", key)
-		}
+	forgetAgent(ctx)
 
+	for _, key := range deleted {
 		ENVS.Delete(key)
 	}
 
-	if isDebugEnabled {
-		slog.InfoContext(ctx, "prune", "offset", offset, "project", PROJECT.Value(), "withGlobal", withGlobal)
+	return nil
+}
+
+// setRemoteEnv writes through the server then reads the key back, the server resolves which scope wins
+func setRemoteEnv(ctx context.Context, key string, value string, isGlobal bool) error {
+	remote := newBackend(nil, Project(), Stage())
+
+	err := remote.Set(ctx, key, value, isGlobal)
+	if err != nil {
+		return err
+	}
+
+	resolved, ok, err := remote.Get(ctx, key)
+	if err != nil || !ok {
+		return err
 	}
 
+	ENVS.Set(key, resolved)
+	os.Setenv(key, resolved)
+
 	return nil
 }
 
-func ClearEnv(ctx context.Context, db *sql.DB, offset int, withGlobal bool) error {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
-
-	prune, err := db.PrepareContext(ctx, `DELETE FROM environments 
-		WHERE uuid
-		IN (
-			SELECT uuid
-			FROM environments
-			WHERE project = $1
-			ORDER BY uuid DESC
-			LIMIT $2
-			OFFSET $3)
-		RETURNING key;`)
+// applyEnv updates ENVS after a write unless the key is overridden by a scope with higher precedence
+func applyEnv(ctx context.Context, store *Store, key string, value string, project string, stage string) error {
+	resolved, err := scopes(ctx, store.db, store.project, store.stage)
 	if err != nil {
 		return err
 	}
-	defer prune.Close()
 
-	var project string
-	if withGlobal {
-		project = "*"
-	} else {
-		project = PROJECT.Value()
-	}
+	written := slices.Index(resolved, scope{Project: project, Stage: stage})
 
-	var rows *sql.Rows
-	if DB_DRIVER.Value() == "sqlite3" {
-		rows, err = prune.QueryContext(ctx, project, "-1", offset)
-		if err != nil {
-			return err
-		}
-	} else if DB_DRIVER.Value() == "pgx" {
-		rows, err = prune.QueryContext(ctx, project, nil, offset)
+	_, ok := ENVS.Get(key)
+	if written > 0 && ok {
+		scopes, args := scopesTable(resolved[:written], 0)
+
+		find, err := store.db.PrepareContext(ctx, fmt.Sprintf(`WITH 
+			%s
+			
+			SELECT uuid FROM environments
+			INNER JOIN scopes
+			ON environments.project = scopes.project AND environments.stage = scopes.stage
+			WHERE environments.key = $%d AND environments.deprecated = 0;`, scopes, len(args)+1))
 		if err != nil {
 			return err
 		}
-	}
-	defer rows.Close()
+		defer find.Close()
 
-	for rows.Next() {
-		var key string
-		err = rows.Scan(&key)
+		rows, err := find.QueryContext(ctx, append(args, key)...)
 		if err != nil {
 			return err
 		}
+		defer rows.Close()
 
-		if isDebugEnabled {
-			slog.InfoContext(ctx, "clear", "env", key)
+		if rows.Next() {
+			return nil
 		}
-
-		ENVS.Delete(key)
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return err
 	}
 
-	if isDebugEnabled {
-		slog.InfoContext(ctx, "clear", "offset", offset, "project", PROJECT.Value(), "withGlobal", withGlobal)
-	}
+	ENVS.Set(key, value)
+	os.Setenv(key, value)
 
-	return err
+	return nil
 }
 
+// Open migrates the database and unlocks the keyring. With DB_DRIVER=http the db is nil
+// and the package functions read and write through the server instead
 func Open(ctx context.Context) (*sql.DB, func() error, error) {
-	isDebugEnabled := ctx.Value(ContextKeyDebug).(bool)
+	isDebugEnabled := isDebug(ctx)
+
+	connectionString, ok := DB_CONNECTION_STRING.Value()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s is required", ErrNotConfigured, DB_CONNECTION_STRING_ENV)
+	}
+
+	// there is no database with DB_DRIVER=http, every read and write goes through the server
+	if DB_DRIVER.Value() == "http" {
+		remote, err := openRemote(connectionString)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		SetRemote(remote)
+
+		return nil, func() error { return nil }, nil
+	}
 
-	db, err := sql.Open(DB_DRIVER.Value(), DB_CONNECTION_STRING.Value())
+	db, err := sql.Open(DB_DRIVER.Value(), connectionString)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -524,11 +618,12 @@ func Open(ctx context.Context) (*sql.DB, func() error, error) {
 		}
 	}
 
-	_, basePath, _, _ := runtime.Caller(0)
-	rootDirectory := filepath.Dir(basePath)
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, nil, err
+	}
 
-	path, _ := filepath.Abs(fmt.Sprintf("%s/../migrations", rootDirectory))
-	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s", path), "kryptos", driver)
+	m, err := migrate.NewWithInstance("iofs", source, "kryptos", driver)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -537,15 +632,82 @@ func Open(ctx context.Context) (*sql.DB, func() error, error) {
 		return nil, nil, err
 	}
 
+	// triggers, sequences and notifications are written per driver, they are versioned in their own migrations table
+	driverMigrationsTable := fmt.Sprintf("kryptos_%s_migrations", DB_DRIVER.Value())
+	if DB_DRIVER.Value() == "sqlite3" {
+		driver, err = sqlite3.WithInstance(db, &sqlite3.Config{MigrationsTable: driverMigrationsTable})
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if DB_DRIVER.Value() == "pgx" {
+		driver, err = pgx.WithInstance(db, &pgx.Config{MigrationsTable: driverMigrationsTable})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	driverSource, err := iofs.New(migrations.FS, DB_DRIVER.Value())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	driverMigrations, err := migrate.NewWithInstance("iofs", driverSource, "kryptos", driver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := driverMigrations.Up(); err != nil && err != migrate.ErrNoChange {
+		return nil, nil, err
+	}
+
+	err = unlockPassphrase(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if keyProviderOverride == nil {
+		provider, err := NewKeyProvider()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		SetKeyProvider(provider)
+	}
+
+	err = bindPendingValues(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if isDebugEnabled {
-		slog.InfoContext(ctx, "create", "table", "environments", "project", PROJECT.Value())
+		slog.InfoContext(ctx, "create", "table", "environments", "project", Project())
 	}
 
 	return db, db.Close, nil
 }
 
+// Author returns the name recorded against changes, preferring AUTHOR over the current user
+func Author() string {
+	author, ok := AUTHOR.Value()
+	if ok {
+		return author
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+
+	return current.Username
+}
+
+// Fingerprint identifies a value without revealing it, see Keyring.Fingerprint
+func Fingerprint(value string) string {
+	return CurrentKeyring().Fingerprint(value)
+}
+
 // From: https://www.melvinvivas.com/how-to-encrypt-and-decrypt-data-using-aes
-func encrypt(plain string, key string) (string, error) {
+func encrypt(plain string, key string, additionalData []byte) (string, error) {
 	decodedKey, _ := hex.DecodeString(key)
 
 	block, err := aes.NewCipher(decodedKey)
@@ -561,12 +723,16 @@ func encrypt(plain string, key string) (string, error) {
 	nonce := make([]byte, gcm.NonceSize())
 	rand.Read(nonce)
 
-	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), additionalData)
 
 	return fmt.Sprintf("%x", sealed), nil
 }
 
-func decrypt(encrypted string, key string) (string, error) {
+// errUnauthenticated is returned by decrypt for a wrong key as well as a changed ciphertext or associated data,
+// the caller tells which from how the key was chosen
+var errUnauthenticated = errors.New("message authentication failed")
+
+func decrypt(encrypted string, key string, additionalData []byte) (string, error) {
 	decodedKey, _ := hex.DecodeString(key)
 
 	decoded, err := hex.DecodeString(encrypted)
@@ -584,12 +750,16 @@ func decrypt(encrypted string, key string) (string, error) {
 		return "", err
 	}
 
+	if len(decoded) < gcm.NonceSize() {
+		return "", errUnauthenticated
+	}
+
 	nonce := decoded[:gcm.NonceSize()]
 	data := decoded[gcm.NonceSize():]
 
-	bytes, err := gcm.Open(nil, nonce, data, nil)
+	bytes, err := gcm.Open(nil, nonce, data, additionalData)
 	if err != nil {
-		return "", err
+		return "", errUnauthenticated
 	}
 
 	return string(bytes), nil
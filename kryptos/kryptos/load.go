@@ -0,0 +1,122 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dogmatiq/ferrite"
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+var (
+	ErrNotConfigured = errors.New("kryptos is not configured")
+	ErrUnreadable    = errors.New("values cannot be opened without IDENTITY or ENCRYPTION_KEY")
+)
+
+// Load resolves the values of a project and stage for an application to use at startup. Options take
+// precedence over DB_DRIVER, DB_CONNECTION_STRING, PROJECT, STAGE, ENCRYPTION_KEY, ENCRYPTION_PASSPHRASE,
+// IDENTITY and KEY_PROVIDER. Unlike the CLI it never prompts, migrates or writes to the database, and
+// fails with ErrUnreadable rather than leave out values it cannot open unless WithUnreadableSkipped is passed
+func Load(ctx context.Context, options ...StoreOption) (*orderedmap.OrderedMap[string, string], error) {
+	keyring := CurrentKeyring()
+	if keyring.Provider == nil {
+		provider, err := NewKeyProvider()
+		if err != nil {
+			return nil, err
+		}
+
+		keyring.Provider = provider
+	}
+
+	passphrase, _ := ENCRYPTION_PASSPHRASE.Value()
+	connectionString, _ := DB_CONNECTION_STRING.Value()
+
+	store := NewStore(append([]StoreOption{
+		WithDriver(DB_DRIVER.Value()),
+		WithConnectionString(connectionString),
+		WithProject(Project()),
+		WithStage(Stage()),
+		WithAuthor(Author()),
+		WithKeyring(keyring),
+		WithPassphrase(passphrase),
+		WithUnreadableSkipped(false),
+	}, options...)...)
+
+	if store.db == nil {
+		if store.connectionString == "" {
+			return nil, fmt.Errorf("%w: %s is required", ErrNotConfigured, DB_CONNECTION_STRING_ENV)
+		}
+
+		db, err := sql.Open(store.driver, store.connectionString)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		store.db = db
+	}
+
+	if store.keyring.Current == "" && store.passphrase != "" {
+		params, ok, err := loadKdfParams(ctx, store.db)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, ErrPassphraseNotConfigured
+		}
+
+		store.keyring.Current, err = params.derive(store.passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bound, err := isBound(ctx, store.db)
+	if err != nil {
+		return nil, err
+	}
+
+	store.keyring.IsBindingRequired = store.keyring.IsBindingRequired || bound
+
+	return store.List(ctx)
+}
+
+// Apply sets the loaded values in the process env, variables that are already set are left as they are
+// so a value can still be overridden when the application is started
+func Apply(envs *orderedmap.OrderedMap[string, string]) error {
+	for key, value := range envs.Iterator() {
+		_, ok := os.LookupEnv(key)
+		if ok {
+			continue
+		}
+
+		err := os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Init loads and applies the values then calls ferrite.Init, so the application's required variables are
+// checked against them. Call it in place of ferrite.Init, before any ferrite value is read
+func Init(ctx context.Context, options ...StoreOption) error {
+	envs, err := Load(ctx, options...)
+	if err != nil {
+		return err
+	}
+
+	err = Apply(envs)
+	if err != nil {
+		return err
+	}
+
+	ferrite.Init()
+
+	return nil
+}
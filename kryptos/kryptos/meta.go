@@ -0,0 +1,41 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// getMeta reads a store-wide setting from kryptos_meta
+func getMeta(ctx context.Context, db queryer, name string) (string, bool, error) {
+	var value string
+	err := db.QueryRowContext(ctx, "SELECT value FROM kryptos_meta WHERE name = $1;", name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func setMeta(ctx context.Context, db queryer, name string, value string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO kryptos_meta(name, value)
+		VALUES($1, $2)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value;`, name, value)
+
+	return err
+}
+
+func deleteMeta(ctx context.Context, db queryer, name string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM kryptos_meta WHERE name = $1;", name)
+
+	return err
+}
@@ -0,0 +1,190 @@
+package kryptos
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrPassphraseNotConfigured = errors.New("store is not in passphrase mode, convert it with kryptos rotate --passphrase")
+
+const META_KDF = "kdf"
+
+// kdfParams are stored in kryptos_meta so every client derives the same key from a passphrase
+type kdfParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      string `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+func newKdfParams() (kdfParams, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return kdfParams{}, err
+	}
+
+	return kdfParams{
+		Algorithm: "argon2id",
+		Salt:      hex.EncodeToString(salt),
+		Time:      3,
+		Memory:    64 * 1024,
+		Threads:   4,
+	}, nil
+}
+
+func (params kdfParams) derive(passphrase string) (string, error) {
+	if params.Algorithm != "argon2id" {
+		return "", fmt.Errorf("unsupported key derivation: %s", params.Algorithm)
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(passphrase), salt, params.Time, params.Memory, params.Threads, 32)
+
+	return hex.EncodeToString(key), nil
+}
+
+func loadKdfParams(ctx context.Context, db *sql.DB) (kdfParams, bool, error) {
+	value, ok, err := getMeta(ctx, db, META_KDF)
+	if err != nil || !ok {
+		return kdfParams{}, false, err
+	}
+
+	var params kdfParams
+	err = json.Unmarshal([]byte(value), &params)
+	if err != nil {
+		return kdfParams{}, false, err
+	}
+
+	return params, true, nil
+}
+
+// passphraseKey derives the encryption key for a passphrase, the salt and parameters are created on first use
+func passphraseKey(ctx context.Context, db *sql.DB, passphrase string) (string, error) {
+	params, ok, err := loadKdfParams(ctx, db)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		params, err = storeKdfParams(ctx, db)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return params.derive(passphrase)
+}
+
+func storeKdfParams(ctx context.Context, db *sql.DB) (kdfParams, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	params, err := newKdfParams()
+	if err != nil {
+		return kdfParams{}, err
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return kdfParams{}, err
+	}
+
+	err = setMeta(ctx, db, META_KDF, string(encoded))
+	if err != nil {
+		return kdfParams{}, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "kdf", "algorithm", params.Algorithm)
+	}
+
+	return params, nil
+}
+
+// RotatePassphrase rotates to the key derived from a passphrase, converting a hex keyed store to passphrase mode.
+// The parameters are stored before any value is sealed with the derived key,
+// so a conversion that is interrupted derives the same key when run again
+func RotatePassphrase(ctx context.Context, db *sql.DB, passphrase string, batchSize int, isDryRun bool) (RotateReport, error) {
+	err := authorizeAll(ctx, db, PERMISSION_ROTATE, "*")
+	if err != nil {
+		return RotateReport{}, err
+	}
+
+	// checked before the parameters are stored, rotateKey refuses it too
+	if provider := CurrentKeyring().Provider; provider != nil {
+		return RotateReport{}, fmt.Errorf("%w: %s", ErrProviderRotation, provider.KeyId())
+	}
+
+	params, ok, err := loadKdfParams(ctx, db)
+	if err != nil {
+		return RotateReport{}, err
+	}
+
+	if !ok && isDryRun {
+		params, err = newKdfParams()
+	} else if !ok {
+		params, err = storeKdfParams(ctx, db)
+	}
+	if err != nil {
+		return RotateReport{}, err
+	}
+
+	next, err := params.derive(passphrase)
+	if err != nil {
+		return RotateReport{}, err
+	}
+
+	return RotateKey(ctx, db, next, batchSize, isDryRun)
+}
+
+// unlockPassphrase derives the encryption key from ENCRYPTION_PASSPHRASE when no ENCRYPTION_KEY is set.
+// Without either the store can only be written to projects with recipients
+func unlockPassphrase(ctx context.Context, db *sql.DB) error {
+	if EncryptionKey() != "" {
+		return nil
+	}
+
+	passphrase, ok := ENCRYPTION_PASSPHRASE.Value()
+	if !ok {
+		return nil
+	}
+
+	_, isConfigured, err := loadKdfParams(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if !isConfigured {
+		var count int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM environments;").Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrPassphraseNotConfigured
+		}
+	}
+
+	key, err := passphraseKey(ctx, db, passphrase)
+	if err != nil {
+		return err
+	}
+
+	SetEncryptionKey(key)
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+var ErrProjectCycle = errors.New("project inheritance cycle")
+
+// Ancestors follows the parents of a project, nearest first, the global scope is implicit
+func Ancestors(ctx context.Context, db *sql.DB, project string) ([]string, error) {
+	parent, err := db.PrepareContext(ctx, "SELECT parent FROM projects WHERE name = $1;")
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+
+	chain := []string{}
+	visited := []string{project}
+
+	current := project
+	for {
+		var next string
+		err = parent.QueryRowContext(ctx, current).Scan(&next)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if next == "*" || next == "" {
+			break
+		}
+
+		if slices.Contains(visited, next) {
+			return nil, fmt.Errorf("%w: %s inherits from %s", ErrProjectCycle, current, next)
+		}
+
+		chain = append(chain, next)
+		visited = append(visited, next)
+		current = next
+	}
+
+	return chain, nil
+}
+
+// SetParent makes child inherit values from parent, "*" only inherits global values
+func SetParent(ctx context.Context, db *sql.DB, child string, parent string) error {
+	isDebugEnabled := isDebug(ctx)
+
+	if child == "*" {
+		return fmt.Errorf("%w: the global scope cannot inherit", ErrProjectCycle)
+	}
+
+	err := authorizeAll(ctx, db, PERMISSION_ADMIN, child)
+	if err != nil {
+		return err
+	}
+
+	if parent != "*" {
+		chain, err := Ancestors(ctx, db, parent)
+		if err != nil {
+			return err
+		}
+
+		if parent == child || slices.Contains(chain, child) {
+			return fmt.Errorf("%w: %s already inherits from %s", ErrProjectCycle, parent, child)
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO projects(name, parent)
+		VALUES($1, $2)
+		ON CONFLICT(name) DO UPDATE SET parent = excluded.parent;`, child, parent)
+	if err != nil {
+		return err
+	}
+
+	err = defaultStore(db).recordChange(ctx, tx, "set-parent", "", parent, child, "")
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	forgetAgent(ctx)
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "inherit", "project", child, "parent", parent)
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package kryptos
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProviderNotConfigured = errors.New("key provider is not configured")
+	ErrProviderRotation      = errors.New("data keys are wrapped by KEY_PROVIDER, rotate the key in the provider instead")
+)
+
+// KeyProvider wraps and unwraps data keys with a key that never leaves the provider
+type KeyProvider interface {
+	// KeyId is stored against each row so the provider that wrapped its data key can unwrap it
+	KeyId() string
+	Wrap(ctx context.Context, dataKey []byte) (string, error)
+	Unwrap(ctx context.Context, wrapped string) ([]byte, error)
+}
+
+var keyProviderOverride KeyProvider = nil
+
+// SetKeyProvider wraps data keys with the provider instead of the encryption key, nil restores the encryption key
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderOverride = provider
+}
+
+// NewKeyProvider returns the provider chosen by KEY_PROVIDER, nil when data keys are wrapped by the encryption key
+func NewKeyProvider() (KeyProvider, error) {
+	switch KEY_PROVIDER.Value() {
+	case "file":
+		path, ok := KEY_FILE.Value()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is required", ErrProviderNotConfigured, KEY_FILE_ENV)
+		}
+
+		return NewFileKeyProvider(path)
+	case "vault":
+		address, isAddressSet := VAULT_ADDR.Value()
+		token, isTokenSet := VAULT_TOKEN.Value()
+		key, isKeySet := VAULT_TRANSIT_KEY.Value()
+		if !isAddressSet || !isTokenSet || !isKeySet {
+			return nil, fmt.Errorf("%w: %s, %s and %s are required", ErrProviderNotConfigured, VAULT_ADDR_ENV, VAULT_TOKEN_ENV, VAULT_TRANSIT_KEY_ENV)
+		}
+
+		return &VaultTransitProvider{
+			Address: address,
+			Token:   token,
+			Key:     key,
+		}, nil
+	case "gcpkms":
+		key, isKeySet := GCP_KMS_KEY.Value()
+		token, isTokenSet := GCP_ACCESS_TOKEN.Value()
+		if !isKeySet || !isTokenSet {
+			return nil, fmt.Errorf("%w: %s and %s are required", ErrProviderNotConfigured, GCP_KMS_KEY_ENV, GCP_ACCESS_TOKEN_ENV)
+		}
+
+		return &GcpKmsProvider{
+			Key:   key,
+			Token: token,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// FileKeyProvider wraps data keys with a hex key read from a local file, a stand-in for a KMS
+type FileKeyProvider struct {
+	key string
+}
+
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := strings.TrimSpace(string(contents))
+	err = validateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &FileKeyProvider{key: key}, nil
+}
+
+func (provider *FileKeyProvider) KeyId() string {
+	return KeyId(provider.key)
+}
+
+func (provider *FileKeyProvider) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	return Keyring{Current: provider.key}.Seal(dataKey, nil)
+}
+
+func (provider *FileKeyProvider) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	return Keyring{Current: provider.key}.Open(wrapped, provider.KeyId(), nil)
+}
+
+// UNWRAP_CACHE_SIZE and UNWRAP_CACHE_TTL bound the data keys a remote provider keeps unwrapped in memory
+const (
+	UNWRAP_CACHE_SIZE = 1024
+	UNWRAP_CACHE_TTL  = 5 * time.Minute
+)
+
+type unwrappedKey struct {
+	dataKey     []byte
+	unwrappedAt time.Time
+}
+
+// unwrapCache keeps the data keys a remote provider unwrapped for a while, so reading a project is not
+// a round trip per row. Keys are dropped once older than the ttl or past UNWRAP_CACHE_SIZE, oldest first,
+// and zeroed when dropped
+type unwrapCache struct {
+	mutex    sync.Mutex
+	dataKeys map[string]unwrappedKey
+	// order is the wrapped keys from the oldest unwrapped to the newest
+	order []string
+}
+
+func (cache *unwrapCache) unwrap(ttl time.Duration, wrapped string, unwrap func() ([]byte, error)) ([]byte, error) {
+	if ttl == 0 {
+		ttl = UNWRAP_CACHE_TTL
+	}
+
+	cache.mutex.Lock()
+	entry, ok := cache.dataKeys[wrapped]
+	if ok && time.Since(entry.unwrappedAt) < ttl {
+		dataKey := slices.Clone(entry.dataKey)
+		cache.mutex.Unlock()
+
+		return dataKey, nil
+	}
+	cache.mutex.Unlock()
+
+	unwrapped, err := unwrap()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.store(ttl, wrapped, slices.Clone(unwrapped))
+
+	return unwrapped, nil
+}
+
+func (cache *unwrapCache) store(ttl time.Duration, wrapped string, dataKey []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.dataKeys == nil {
+		cache.dataKeys = map[string]unwrappedKey{}
+	}
+
+	if _, ok := cache.dataKeys[wrapped]; ok {
+		cache.drop(slices.Index(cache.order, wrapped))
+	}
+
+	for len(cache.order) > 0 && (len(cache.order) >= UNWRAP_CACHE_SIZE || time.Since(cache.dataKeys[cache.order[0]].unwrappedAt) >= ttl) {
+		cache.drop(0)
+	}
+
+	cache.dataKeys[wrapped] = unwrappedKey{dataKey: dataKey, unwrappedAt: time.Now()}
+	cache.order = append(cache.order, wrapped)
+}
+
+// drop zeroes and forgets the data key at index of order, the caller holds the mutex
+func (cache *unwrapCache) drop(index int) {
+	wrapped := cache.order[index]
+	clear(cache.dataKeys[wrapped].dataKey)
+	delete(cache.dataKeys, wrapped)
+	cache.order = slices.Delete(cache.order, index, index+1)
+}
+
+// VaultTransitProvider wraps data keys with a HashiCorp Vault transit key
+type VaultTransitProvider struct {
+	Address string
+	Token   string
+	Key     string
+	Client  *http.Client
+	// CacheTtl is how long unwrapped data keys are kept, UNWRAP_CACHE_TTL when zero
+	CacheTtl time.Duration
+
+	unwrapped unwrapCache
+}
+
+func (provider *VaultTransitProvider) KeyId() string {
+	return fmt.Sprintf("vault:%s", provider.Key)
+}
+
+func (provider *VaultTransitProvider) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	request := map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}
+
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+
+	err := postJson(ctx, provider.Client, provider.url("encrypt"), provider.headers(), request, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Data.Ciphertext, nil
+}
+
+func (provider *VaultTransitProvider) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	return provider.unwrapped.unwrap(provider.CacheTtl, wrapped, func() ([]byte, error) {
+		request := map[string]string{
+			"ciphertext": wrapped,
+		}
+
+		var response struct {
+			Data struct {
+				Plaintext string `json:"plaintext"`
+			} `json:"data"`
+		}
+
+		err := postJson(ctx, provider.Client, provider.url("decrypt"), provider.headers(), request, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		return base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	})
+}
+
+func (provider *VaultTransitProvider) url(operation string) string {
+	return fmt.Sprintf("%s/v1/transit/%s/%s", strings.TrimSuffix(provider.Address, "/"), operation, provider.Key)
+}
+
+func (provider *VaultTransitProvider) headers() map[string]string {
+	return map[string]string{
+		"X-Vault-Token": provider.Token,
+	}
+}
+
+// GcpKmsProvider wraps data keys with a Google Cloud KMS key,
+// Key is the full resource name projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+type GcpKmsProvider struct {
+	Key      string
+	Token    string
+	Endpoint string
+	Client   *http.Client
+	// CacheTtl is how long unwrapped data keys are kept, UNWRAP_CACHE_TTL when zero
+	CacheTtl time.Duration
+
+	unwrapped unwrapCache
+}
+
+func (provider *GcpKmsProvider) KeyId() string {
+	return fmt.Sprintf("gcpkms:%s", provider.Key)
+}
+
+func (provider *GcpKmsProvider) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	request := map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}
+
+	var response struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+
+	err := postJson(ctx, provider.Client, provider.url("encrypt"), provider.headers(), request, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Ciphertext, nil
+}
+
+func (provider *GcpKmsProvider) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	return provider.unwrapped.unwrap(provider.CacheTtl, wrapped, func() ([]byte, error) {
+		request := map[string]string{
+			"ciphertext": wrapped,
+		}
+
+		var response struct {
+			Plaintext string `json:"plaintext"`
+		}
+
+		err := postJson(ctx, provider.Client, provider.url("decrypt"), provider.headers(), request, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		return base64.StdEncoding.DecodeString(response.Plaintext)
+	})
+}
+
+func (provider *GcpKmsProvider) url(operation string) string {
+	endpoint := provider.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+
+	return fmt.Sprintf("%s/v1/%s:%s", strings.TrimSuffix(endpoint, "/"), provider.Key, operation)
+}
+
+func (provider *GcpKmsProvider) headers() map[string]string {
+	return map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", provider.Token),
+	}
+}
+
+func postJson(ctx context.Context, client *http.Client, url string, headers map[string]string, body any, out any) error {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+		return fmt.Errorf("%s: %s %s", url, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(response.Body).Decode(out)
+}
@@ -0,0 +1,363 @@
+package kryptos
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrNoIdentity        = errors.New("no identity can open this value")
+	ErrInvalidPublicKey  = errors.New("public key must be 32 bytes of hex")
+	ErrRecipientNotFound = errors.New("recipient not found")
+)
+
+// KEY_ID_RECIPIENTS marks rows whose data key is sealed to the project recipients instead of the encryption key
+const KEY_ID_RECIPIENTS = "recipients"
+
+type Recipient struct {
+	Project   string
+	Name      string
+	PublicKey string
+}
+
+// GenerateIdentity creates an X25519 private key and the public key values are sealed to
+func GenerateIdentity() (string, string, error) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(identity.Bytes()), hex.EncodeToString(identity.PublicKey().Bytes()), nil
+}
+
+func parsePublicKey(publicKey string) (*ecdh.PublicKey, error) {
+	decoded, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	parsed, err := ecdh.X25519().NewPublicKey(decoded)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return parsed, nil
+}
+
+func recipientWrapKey(shared []byte, ephemeral []byte, recipient []byte) (string, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+
+	wrapKey := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("kryptos x25519")), wrapKey)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(wrapKey), nil
+}
+
+// sealToRecipients wraps the data key once per recipient with a key agreed with an ephemeral key,
+// the stanzas are separated by ;
+func sealToRecipients(dataKey []byte, recipients []Recipient) (string, error) {
+	stanzas := []string{}
+
+	for _, recipient := range recipients {
+		publicKey, err := parsePublicKey(recipient.PublicKey)
+		if err != nil {
+			return "", err
+		}
+
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+
+		shared, err := ephemeral.ECDH(publicKey)
+		if err != nil {
+			return "", err
+		}
+
+		wrapKey, err := recipientWrapKey(shared, ephemeral.PublicKey().Bytes(), publicKey.Bytes())
+		if err != nil {
+			return "", err
+		}
+
+		wrapped, err := encrypt(string(dataKey), wrapKey, nil)
+		if err != nil {
+			return "", err
+		}
+
+		stanzas = append(stanzas, ciphertext{
+			Version:   CIPHERTEXT_VERSION,
+			Algorithm: ALGORITHM_X25519,
+			KeyId:     KeyId(recipient.PublicKey),
+			Data:      hex.EncodeToString(ephemeral.PublicKey().Bytes()) + wrapped,
+		}.String())
+	}
+
+	return strings.Join(stanzas, ";"), nil
+}
+
+func (keyring Keyring) openRecipient(sealed ciphertext) ([]byte, error) {
+	if len(keyring.Identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+
+	if len(sealed.Data) < 64 {
+		return nil, fmt.Errorf("%w: truncated stanza", ErrUnsupportedCiphertext)
+	}
+
+	ephemeralKey, err := parsePublicKey(sealed.Data[:64])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, identity := range keyring.Identities {
+		decoded, err := hex.DecodeString(identity)
+		if err != nil {
+			continue
+		}
+
+		privateKey, err := ecdh.X25519().NewPrivateKey(decoded)
+		if err != nil {
+			continue
+		}
+
+		publicKey := hex.EncodeToString(privateKey.PublicKey().Bytes())
+		if KeyId(publicKey) != sealed.KeyId {
+			continue
+		}
+
+		shared, err := privateKey.ECDH(ephemeralKey)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapKey, err := recipientWrapKey(shared, ephemeralKey.Bytes(), privateKey.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		dataKey, err := decrypt(sealed.Data[64:], wrapKey, nil)
+		if errors.Is(err, errUnauthenticated) {
+			// the stanza names the identity it was sealed to
+			return nil, ErrTampered
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(dataKey), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoIdentity, sealed.KeyId)
+}
+
+// openRecipients opens the first stanza an identity in the keyring was sealed to
+func (keyring Keyring) openRecipients(stanzas string) ([]byte, error) {
+	err := ErrNoIdentity
+	for _, stanza := range strings.Split(stanzas, ";") {
+		var dataKey []byte
+		dataKey, err = keyring.Open(stanza, "", nil)
+		if err == nil {
+			return dataKey, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Recipients lists the public keys values in a project are sealed to
+func Recipients(ctx context.Context, db queryer, project string) ([]Recipient, error) {
+	rows, err := db.QueryContext(ctx, "SELECT project, name, public_key FROM recipients WHERE project = $1 ORDER BY name;", project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []Recipient{}
+	for rows.Next() {
+		var recipient Recipient
+		err = rows.Scan(&recipient.Project, &recipient.Name, &recipient.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
+
+// AddRecipient seals every value in the project, including previous versions, to the new set of recipients
+func AddRecipient(ctx context.Context, db *sql.DB, project string, name string, publicKey string) (int, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	_, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	err = authorizeAll(ctx, db, PERMISSION_ADMIN, project)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO recipients(project, name, public_key)
+		VALUES($1, $2, $3)
+		ON CONFLICT(project, name) DO UPDATE SET public_key = excluded.public_key;`, project, name, publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	resealed, err := resealProject(ctx, tx, project, false)
+	if err != nil {
+		return 0, err
+	}
+
+	err = defaultStore(db).recordChange(ctx, tx, "recipients", "", "", project, "")
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "recipient", "project", project, "name", name, "resealed", resealed)
+	}
+
+	return resealed, nil
+}
+
+// RemoveRecipient seals every value in the project again with a fresh data key without the recipient,
+// once the last recipient is removed values are sealed with the encryption key again. The recipient may have
+// kept the values it could open, revoking its access needs the secrets rotated as well
+func RemoveRecipient(ctx context.Context, db *sql.DB, project string, name string) (int, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	err := authorizeAll(ctx, db, PERMISSION_ADMIN, project)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM recipients WHERE project = $1 AND name = $2;", project, name)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrRecipientNotFound, name)
+	}
+
+	resealed, err := resealProject(ctx, tx, project, true)
+	if err != nil {
+		return 0, err
+	}
+
+	err = defaultStore(db).recordChange(ctx, tx, "recipients", "", "", project, "")
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "recipient", "project", project, "removed", name, "resealed", resealed)
+	}
+
+	return resealed, nil
+}
+
+// resealProject wraps the data key of every value in the project for its recipients, with isRekeyed every
+// value is sealed with a fresh data key instead so a data key unwrapped by a removed recipient is useless
+func resealProject(ctx context.Context, tx *sql.Tx, project string, isRekeyed bool) (int, error) {
+	recipients, err := Recipients(ctx, tx, project)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT uuid, key, project, stage, value, data_key, key_id FROM environments WHERE project = $1 ORDER BY uuid;", project)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		uuid   string
+		sealed envelope
+	}
+
+	batch := []row{}
+	for rows.Next() {
+		var id string
+		var sealed envelope
+		err = rows.Scan(&id, &sealed.Binding.Key, &sealed.Binding.Project, &sealed.Binding.Stage, &sealed.Value, &sealed.DataKey, &sealed.KeyId)
+		if err != nil {
+			return 0, err
+		}
+		sealed.Binding.Uuid = id
+
+		batch = append(batch, row{uuid: id, sealed: sealed})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	keyring := CurrentKeyring()
+	for _, row := range batch {
+		var resealed envelope
+		if isRekeyed {
+			resealed, err = rekeyEnvelope(ctx, row.sealed, keyring, recipients)
+		} else {
+			resealed, err = rewrapEnvelope(ctx, row.sealed, keyring, keyring, recipients)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", row.uuid, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE environments SET value = $1, data_key = $2, key_id = $3 WHERE uuid = $4;",
+			resealed.Value, resealed.DataKey, resealed.KeyId, row.uuid)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
@@ -0,0 +1,321 @@
+package kryptos
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+	"github.com/joho/godotenv"
+)
+
+var (
+	ErrRemote            = errors.New("kryptos server error")
+	ErrRemoteUnsupported = errors.New("not supported with DB_DRIVER=http")
+	ErrNoToken           = errors.New("API_TOKEN or a profile with API_TOKEN must be set")
+	ErrKeyNotFound       = errors.New("key not found")
+)
+
+// backend is the store values are read from and written to, a Remote when DB_DRIVER is http
+type backend interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	ListAsOf(ctx context.Context, asOf time.Time) (*orderedmap.OrderedMap[string, string], error)
+	Set(ctx context.Context, key string, value string, isGlobal bool) error
+	Delete(ctx context.Context, key string, includeDeprecated bool, includeGlobal bool) ([]string, error)
+	Rename(ctx context.Context, previous string, next string, isGlobal bool, isProject bool) error
+	Prune(ctx context.Context, offset int, withGlobal bool) (int, error)
+	Clear(ctx context.Context, offset int, withGlobal bool) ([]string, error)
+	Stats(ctx context.Context) ([]envStat, error)
+}
+
+var remoteOverride *Remote = nil
+
+// SetRemote reads and writes through a kryptos server instead of the database, nil restores the database
+func SetRemote(remote *Remote) {
+	remoteOverride = remote
+}
+
+// IsRemote is true once Open has connected to a kryptos server instead of a database
+func IsRemote() bool {
+	return remoteOverride != nil
+}
+
+func newBackend(db *sql.DB, project string, stage string) backend {
+	if remoteOverride != nil {
+		remote := *remoteOverride
+		remote.Project = project
+		remote.Stage = stage
+
+		return &remote
+	}
+
+	store := defaultStore(db)
+	store.project = project
+	store.stage = stage
+
+	return store
+}
+
+// Remote reads and writes values through the API of kryptos serve, the server holds the encryption key
+type Remote struct {
+	Url     string
+	Token   string
+	Project string
+	Stage   string
+	Client  *http.Client
+}
+
+// profileToken reads API_TOKEN from the dotenv file at PROFILE_FILE, ~/.config/kryptos/profile by default
+func profileToken() (string, error) {
+	path, ok := PROFILE_FILE.Value()
+	if !ok {
+		configDirectory, err := os.UserConfigDir()
+		if err != nil {
+			return "", ErrNoToken
+		}
+
+		path = filepath.Join(configDirectory, "kryptos", "profile")
+	}
+
+	profile, err := godotenv.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNoToken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token, ok := profile[API_TOKEN_ENV]
+	if !ok || token == "" {
+		return "", ErrNoToken
+	}
+
+	return token, nil
+}
+
+// openRemote connects to the server at DB_CONNECTION_STRING with API_TOKEN, or the token in the profile
+func openRemote(connectionString string) (*Remote, error) {
+	token, ok := API_TOKEN.Value()
+	if !ok {
+		var err error
+		token, err = profileToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Remote{
+		Url:     strings.TrimSuffix(connectionString, "/"),
+		Token:   token,
+		Project: Project(),
+		Stage:   Stage(),
+	}, nil
+}
+
+type remoteEnv struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type remoteStat struct {
+	Key     string `json:"key"`
+	Project string `json:"project"`
+	Stage   string `json:"stage"`
+	Count   int    `json:"count"`
+}
+
+func (remote *Remote) do(ctx context.Context, method string, path string, query url.Values, body any, out any) (int, error) {
+	client := remote.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("project", remote.Project)
+	query.Set("stage", remote.Stage)
+
+	var encoded bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&encoded).Encode(body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s?%s", remote.Url, path, query.Encode()), &encoded)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+remote.Token)
+	req.Header.Set("Content-Type", "application/json")
+	if reason := reason(ctx); reason != "" {
+		req.Header.Set(REASON_HEADER, reason)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		failure := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&failure)
+
+		// a wrong URL or path prefix is answered with 404 too, only a key the server looked up is no value
+		if res.StatusCode == http.StatusNotFound && strings.HasPrefix(failure.Error, ErrKeyNotFound.Error()) {
+			return res.StatusCode, nil
+		}
+
+		if res.StatusCode == http.StatusUnauthorized {
+			return res.StatusCode, fmt.Errorf("%w: %s", ErrInvalidToken, failure.Error)
+		}
+
+		return res.StatusCode, fmt.Errorf("%w: %s %s", ErrRemote, res.Status, failure.Error)
+	}
+
+	if out != nil {
+		err = json.NewDecoder(res.Body).Decode(out)
+		if err != nil {
+			return res.StatusCode, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+func (remote *Remote) Get(ctx context.Context, key string) (string, bool, error) {
+	env := remoteEnv{}
+	status, err := remote.do(ctx, http.MethodGet, "/v1/envs/"+url.PathEscape(key), nil, nil, &env)
+	if err != nil || status == http.StatusNotFound {
+		return "", false, err
+	}
+
+	return env.Value, true, nil
+}
+
+func (remote *Remote) List(ctx context.Context) (*orderedmap.OrderedMap[string, string], error) {
+	return remote.ListAsOf(ctx, time.Time{})
+}
+
+func (remote *Remote) ListAsOf(ctx context.Context, asOf time.Time) (*orderedmap.OrderedMap[string, string], error) {
+	query := url.Values{}
+	if !asOf.IsZero() {
+		query.Set("as_of", asOf.Format(time.RFC3339))
+	}
+
+	response := struct {
+		Envs []remoteEnv `json:"envs"`
+	}{}
+	_, err := remote.do(ctx, http.MethodGet, "/v1/envs", query, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	envs := orderedmap.NewOrderedMap[string, string]()
+	for _, env := range response.Envs {
+		envs.Set(env.Key, env.Value)
+	}
+
+	return envs, nil
+}
+
+func (remote *Remote) Set(ctx context.Context, key string, value string, isGlobal bool) error {
+	request := struct {
+		Value    string `json:"value"`
+		IsGlobal bool   `json:"global"`
+	}{value, isGlobal}
+
+	_, err := remote.do(ctx, http.MethodPut, "/v1/envs/"+url.PathEscape(key), nil, request, nil)
+
+	return err
+}
+
+func (remote *Remote) Delete(ctx context.Context, key string, includeDeprecated bool, includeGlobal bool) ([]string, error) {
+	query := url.Values{}
+	query.Set("all", strconv.FormatBool(includeDeprecated))
+	query.Set("global", strconv.FormatBool(includeGlobal))
+
+	response := struct {
+		Deleted []string `json:"deleted"`
+	}{}
+	_, err := remote.do(ctx, http.MethodDelete, "/v1/envs/"+url.PathEscape(key), query, nil, &response)
+
+	return response.Deleted, err
+}
+
+func (remote *Remote) Rename(ctx context.Context, previous string, next string, isGlobal bool, isProject bool) error {
+	request := struct {
+		Previous  string `json:"previous"`
+		Next      string `json:"next"`
+		IsGlobal  bool   `json:"global"`
+		IsProject bool   `json:"project"`
+	}{previous, next, isGlobal, isProject}
+
+	status, err := remote.do(ctx, http.MethodPost, "/v1/mv", nil, request, nil)
+	if err == nil && status == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrRemote, http.StatusText(status))
+	}
+
+	return err
+}
+
+func (remote *Remote) prune(ctx context.Context, offset int, withGlobal bool, isAll bool) (int, []string, error) {
+	request := struct {
+		Offset   int  `json:"offset"`
+		IsGlobal bool `json:"global"`
+		IsAll    bool `json:"all"`
+	}{offset, withGlobal, isAll}
+
+	response := struct {
+		Pruned  int      `json:"pruned"`
+		Deleted []string `json:"deleted"`
+	}{}
+	_, err := remote.do(ctx, http.MethodPost, "/v1/prune", nil, request, &response)
+
+	return response.Pruned, response.Deleted, err
+}
+
+func (remote *Remote) Prune(ctx context.Context, offset int, withGlobal bool) (int, error) {
+	pruned, _, err := remote.prune(ctx, offset, withGlobal, false)
+
+	return pruned, err
+}
+
+func (remote *Remote) Clear(ctx context.Context, offset int, withGlobal bool) ([]string, error) {
+	_, deleted, err := remote.prune(ctx, offset, withGlobal, true)
+
+	return deleted, err
+}
+
+func (remote *Remote) Stats(ctx context.Context) ([]envStat, error) {
+	response := struct {
+		Stats []remoteStat `json:"stats"`
+	}{}
+	_, err := remote.do(ctx, http.MethodGet, "/v1/stats", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []envStat{}
+	for _, stat := range response.Stats {
+		stats = append(stats, envStat(stat))
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,183 @@
+package kryptos
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidShare  = errors.New("invalid share")
+	ErrShareMismatch = errors.New("shares do not rebuild the encryption key")
+)
+
+// Share is one point on each of the polynomials hiding the bytes of a key,
+// serialised as <key id>-<threshold>-<x>-<hex>
+type Share struct {
+	KeyId     string
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+func (share Share) String() string {
+	return fmt.Sprintf("%s-%d-%d-%x", share.KeyId, share.Threshold, share.X, share.Y)
+}
+
+func ParseShare(serialised string) (Share, error) {
+	parts := strings.Split(strings.TrimSpace(serialised), "-")
+	if len(parts) != 4 {
+		return Share{}, ErrInvalidShare
+	}
+
+	threshold, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Share{}, ErrInvalidShare
+	}
+
+	x, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil || x == 0 {
+		return Share{}, ErrInvalidShare
+	}
+
+	y, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return Share{}, ErrInvalidShare
+	}
+
+	return Share{
+		KeyId:     parts[0],
+		Threshold: threshold,
+		X:         byte(x),
+		Y:         y,
+	}, nil
+}
+
+// SplitKey splits a key into shares so that any threshold of them rebuild it
+func SplitKey(key string, shares int, threshold int) ([]Share, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if threshold < 2 || shares < threshold || shares > 255 {
+		return nil, fmt.Errorf("%w: need 2 <= threshold <= shares <= 255", ErrInvalidShare)
+	}
+
+	secret, _ := hex.DecodeString(key)
+
+	split := make([]Share, shares)
+	for i := range split {
+		split[i] = Share{
+			KeyId:     KeyId(key),
+			Threshold: threshold,
+			X:         byte(i + 1),
+			Y:         make([]byte, len(secret)),
+		}
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, value := range secret {
+		_, err = rand.Read(coefficients[1:])
+		if err != nil {
+			return nil, err
+		}
+		coefficients[0] = value
+
+		for i := range split {
+			split[i].Y[b] = evaluate(coefficients, split[i].X)
+		}
+	}
+
+	return split, nil
+}
+
+// CombineShares rebuilds the key from at least threshold shares of the same key
+func CombineShares(shares []Share) (string, error) {
+	if len(shares) == 0 {
+		return "", ErrShareMismatch
+	}
+
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return "", fmt.Errorf("%w: %d of %d shares", ErrShareMismatch, len(shares), first.Threshold)
+	}
+
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if share.KeyId != first.KeyId || len(share.Y) != len(first.Y) || seen[share.X] {
+			return "", fmt.Errorf("%w: shares are duplicated or from different keys", ErrShareMismatch)
+		}
+
+		seen[share.X] = true
+	}
+
+	secret := make([]byte, len(first.Y))
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j, other := range shares {
+				if i == j {
+					continue
+				}
+
+				basis = gfMul(basis, gfDiv(other.X, other.X^share.X))
+			}
+
+			value ^= gfMul(share.Y[b], basis)
+		}
+
+		secret[b] = value
+	}
+
+	key := hex.EncodeToString(secret)
+	if KeyId(key) != first.KeyId {
+		return "", ErrShareMismatch
+	}
+
+	return key, nil
+}
+
+// evaluate computes the polynomial at x in GF(2^8)
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+
+	return result
+}
+
+// gfMul multiplies in GF(2^8) reduced by the AES polynomial x^8 + x^4 + x^3 + x + 1
+func gfMul(a byte, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+
+		b >>= 1
+	}
+
+	return product
+}
+
+// gfDiv multiplies a by the inverse of b, b^254
+func gfDiv(a byte, b byte) byte {
+	inverse := byte(1)
+	for i := 0; i < 254; i++ {
+		inverse = gfMul(inverse, b)
+	}
+
+	return gfMul(a, inverse)
+}
@@ -0,0 +1,1120 @@
+package kryptos
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v2"
+	"github.com/google/uuid"
+)
+
+// Store reads and writes the values of one project and stage and is safe for concurrent use.
+// It reads no package state, the keyring with its provider and whether binding is required come from its options
+type Store struct {
+	db      *sql.DB
+	driver  string
+	project string
+	stage   string
+	author  string
+
+	// connectionString and passphrase are only read by Load, and connectionString by Watch to LISTEN on pgx
+	connectionString string
+	passphrase       string
+	pollInterval     time.Duration
+
+	// isUnreadableSkipped leaves out the values sealed to recipients without an IDENTITY, or to a key that is not set
+	isUnreadableSkipped bool
+
+	mutex   sync.RWMutex
+	keyring Keyring
+}
+
+type StoreOption func(store *Store)
+
+func WithDb(db *sql.DB) StoreOption {
+	return func(store *Store) {
+		store.db = db
+	}
+}
+
+// WithDriver is the database/sql driver name, sqlite3 or pgx
+func WithDriver(driver string) StoreOption {
+	return func(store *Store) {
+		store.driver = driver
+	}
+}
+
+// WithConnectionString is used by Load when no db is given
+func WithConnectionString(connectionString string) StoreOption {
+	return func(store *Store) {
+		store.connectionString = connectionString
+	}
+}
+
+func WithProject(project string) StoreOption {
+	return func(store *Store) {
+		store.project = project
+	}
+}
+
+func WithStage(stage string) StoreOption {
+	return func(store *Store) {
+		store.stage = stage
+	}
+}
+
+func WithAuthor(author string) StoreOption {
+	return func(store *Store) {
+		store.author = author
+	}
+}
+
+func WithKeyring(keyring Keyring) StoreOption {
+	return func(store *Store) {
+		store.keyring = keyring
+	}
+}
+
+// WithPassphrase derives the encryption key in Load when the keyring has none
+func WithPassphrase(passphrase string) StoreOption {
+	return func(store *Store) {
+		store.passphrase = passphrase
+	}
+}
+
+// WithPollInterval is how often Watch reads the changes table when it cannot LISTEN, every second by default
+func WithPollInterval(interval time.Duration) StoreOption {
+	return func(store *Store) {
+		store.pollInterval = interval
+	}
+}
+
+// WithKeyProvider wraps data keys with the provider, apply it after WithKeyring
+func WithKeyProvider(provider KeyProvider) StoreOption {
+	return func(store *Store) {
+		store.keyring.Provider = provider
+	}
+}
+
+// WithBindingRequired rejects values that are not bound to their row, apply it after WithKeyring
+func WithBindingRequired(isRequired bool) StoreOption {
+	return func(store *Store) {
+		store.keyring.IsBindingRequired = isRequired
+	}
+}
+
+// WithUnreadableSkipped leaves out the values that cannot be opened without IDENTITY or ENCRYPTION_KEY,
+// otherwise reading fails with ErrUnreadable listing their keys
+func WithUnreadableSkipped(isSkipped bool) StoreOption {
+	return func(store *Store) {
+		store.isUnreadableSkipped = isSkipped
+	}
+}
+
+// NewStore defaults to the global project on sqlite3, skipping the values it cannot open like the CLI
+func NewStore(options ...StoreOption) *Store {
+	store := &Store{
+		driver:              "sqlite3",
+		project:             "*",
+		author:              "unknown",
+		isUnreadableSkipped: true,
+	}
+
+	for _, option := range options {
+		option(store)
+	}
+
+	return store
+}
+
+// defaultStore is configured from the environment and the overrides set by the CLI
+func defaultStore(db *sql.DB) *Store {
+	return NewStore(
+		WithDb(db),
+		WithDriver(DB_DRIVER.Value()),
+		WithProject(Project()),
+		WithStage(Stage()),
+		WithAuthor(Author()),
+		WithKeyring(CurrentKeyring()),
+	)
+}
+
+func (store *Store) Project() string {
+	return store.project
+}
+
+func (store *Store) Stage() string {
+	return store.stage
+}
+
+func (store *Store) Keyring() Keyring {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.keyring
+}
+
+// Rotate re-wraps every data key with next, see RotateKey. The store seals with next once the rotation
+// is verified and keeps the key it replaces to open values, the process encryption key is left as it is
+func (store *Store) Rotate(ctx context.Context, next string, batchSize int, isDryRun bool) (RotateReport, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	report, err := store.rotate(ctx, next, batchSize, isDryRun)
+	if err != nil || isDryRun {
+		return report, err
+	}
+
+	if store.keyring.Current != "" && store.keyring.Current != next {
+		store.keyring.Retired = append(slices.Clone(store.keyring.Retired), store.keyring.Current)
+	}
+	store.keyring.Current = next
+
+	return report, nil
+}
+
+// isDebug is false when the context carries no debug flag, library callers rarely set one
+func isDebug(ctx context.Context) bool {
+	isDebugEnabled, _ := ctx.Value(ContextKeyDebug).(bool)
+
+	return isDebugEnabled
+}
+
+// Get resolves a single key, ok is false when no scope holds it
+func (store *Store) Get(ctx context.Context, key string) (string, bool, error) {
+	envs, err := store.List(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	value, ok := envs.Get(key)
+
+	return value, ok, nil
+}
+
+// List resolves every key visible to the store scope
+func (store *Store) List(ctx context.Context) (*orderedmap.OrderedMap[string, string], error) {
+	return store.ListAsOf(ctx, time.Time{})
+}
+
+// ListAsOf resolves every key as it was at asOf
+func (store *Store) ListAsOf(ctx context.Context, asOf time.Time) (*orderedmap.OrderedMap[string, string], error) {
+	envs, _, err := store.resolve(ctx, store.project, store.stage, asOf)
+
+	return envs, err
+}
+
+// ListSourcesAsOf is ListAsOf along with the project each key resolves from, an ancestor or * for
+// inherited values. Grants are checked against that project rather than the one of the store
+func (store *Store) ListSourcesAsOf(ctx context.Context, asOf time.Time) (*orderedmap.OrderedMap[string, string], map[string]string, error) {
+	return store.resolve(ctx, store.project, store.stage, asOf)
+}
+
+// orderByKey sorts case insensitively on both drivers
+func (store *Store) orderByKey(column string) string {
+	if store.driver == "pgx" {
+		return fmt.Sprintf("ORDER BY LOWER(%s), %s", column, column)
+	}
+
+	return fmt.Sprintf("ORDER BY %s COLLATE NOCASE", column)
+}
+
+// Stats counts the versions of each key visible to the store scope
+func (store *Store) Stats(ctx context.Context) ([]envStat, error) {
+	resolved, err := scopes(ctx, store.db, store.project, store.stage)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, args := scopesTable(resolved, 0)
+
+	rows, err := store.db.QueryContext(ctx, fmt.Sprintf(`WITH 
+		%s,
+		scoped_environments AS (SELECT environments.key, scopes.project, scopes.stage, scopes.precedence
+			FROM environments
+			INNER JOIN scopes
+			ON environments.project = scopes.project AND environments.stage = scopes.stage),
+		preferred AS (SELECT key, MIN(precedence) AS precedence
+			FROM scoped_environments
+			GROUP BY key)
+		
+		SELECT scoped_environments.key, scoped_environments.project, scoped_environments.stage, COUNT(*) FROM scoped_environments
+		INNER JOIN preferred
+		ON preferred.key = scoped_environments.key AND preferred.precedence = scoped_environments.precedence
+		GROUP BY scoped_environments.key, scoped_environments.project, scoped_environments.stage
+		%s;
+	`, scopes, store.orderByKey("scoped_environments.key")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envs := []envStat{}
+
+	for rows.Next() {
+		var key string
+		var project string
+		var stage string
+		var count int
+		err = rows.Scan(&key, &project, &stage, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		envStat := envStat{
+			Key:     key,
+			Project: project,
+			Stage:   stage,
+			Count:   count,
+		}
+
+		envs = append(envs, envStat)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return envs, nil
+}
+
+// History lists the versions of key, newest first
+func (store *Store) History(ctx context.Context, key string, isGlobal bool) ([]envVersion, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	project, stage := store.writeScope(isGlobal)
+
+	history, err := store.db.PrepareContext(ctx, `SELECT uuid, value, data_key, key_id, author, deprecated
+		FROM environments
+		WHERE key = $1 AND project = $2 AND stage = $3
+		ORDER BY uuid DESC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer history.Close()
+
+	rows, err := history.QueryContext(ctx, key, project, stage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []envVersion{}
+
+	for rows.Next() {
+		var id string
+		var sealed envelope
+		var author string
+		var deprecated int
+		err = rows.Scan(&id, &sealed.Value, &sealed.DataKey, &sealed.KeyId, &author, &deprecated)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Binding = binding{Uuid: id, Key: key, Project: project, Stage: stage}
+
+		decrypted, err := openEnvelope(ctx, sealed, store.Keyring())
+		if err != nil {
+			return nil, err
+		}
+
+		var createdAt time.Time
+		parsed, err := uuid.Parse(id)
+		if err == nil {
+			seconds, nanoseconds := parsed.Time().UnixTime()
+			createdAt = time.Unix(seconds, nanoseconds).UTC()
+		}
+
+		envVersion := envVersion{
+			Uuid:        id,
+			Key:         key,
+			Project:     project,
+			Stage:       stage,
+			Author:      author,
+			CreatedAt:   createdAt,
+			Fingerprint: store.Keyring().Fingerprint(decrypted),
+			Deprecated:  deprecated == 1,
+		}
+
+		versions = append(versions, envVersion)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range versions {
+		versions[i].Version = len(versions) - i
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "history", "env", key, "project", project, "stage", stage, "versions", len(versions))
+	}
+
+	return versions, nil
+}
+
+func (store *Store) resolve(ctx context.Context, project string, stage string, asOf time.Time) (*orderedmap.OrderedMap[string, string], map[string]string, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	resolved, err := scopes(ctx, store.db, project, stage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes, args := scopesTable(resolved, 0)
+
+	// removed is 1 for a version deleted by rm, its value cannot be returned for the time it was current
+	currentEnvironments := `current_environments AS (SELECT environments.uuid, environments.key, environments.project, environments.stage, environments.value, environments.data_key, environments.key_id, scopes.precedence, 0 AS removed
+			FROM environments
+			INNER JOIN scopes
+			ON environments.project = scopes.project AND environments.stage = scopes.stage
+			WHERE environments.deprecated = 0)`
+
+	if !asOf.IsZero() {
+		upperBound := uuidUpperBound(asOf)
+
+		err = store.renamedSince(ctx, scopes, args, upperBound)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// versions are the values set until asOf along with the versions deleted by rm, and the removals
+		// themselves (removed is 2) so the versions rm left behind are not returned as current
+		currentEnvironments = fmt.Sprintf(`versions AS (SELECT environments.uuid, environments.key, environments.project, environments.stage, environments.value, environments.data_key, environments.key_id, scopes.precedence, 0 AS removed
+			FROM environments
+			INNER JOIN scopes
+			ON environments.project = scopes.project AND environments.stage = scopes.stage
+			WHERE environments.uuid <= $%[1]d
+			UNION ALL
+			SELECT tombstones.version, tombstones.key, tombstones.project, tombstones.stage, '', '', '', scopes.precedence, 1
+			FROM tombstones
+			INNER JOIN scopes
+			ON tombstones.project = scopes.project AND tombstones.stage = scopes.stage
+			WHERE tombstones.op = 'rm' AND tombstones.version != '' AND tombstones.version <= $%[1]d
+			UNION ALL
+			SELECT tombstones.uuid, tombstones.key, tombstones.project, tombstones.stage, '', '', '', scopes.precedence, 2
+			FROM tombstones
+			INNER JOIN scopes
+			ON tombstones.project = scopes.project AND tombstones.stage = scopes.stage
+			WHERE tombstones.op = 'rm' AND tombstones.uuid <= $%[1]d),
+		latest AS (SELECT uuid, key, project, stage, value, data_key, key_id, precedence, removed
+			FROM versions
+			WHERE uuid = (SELECT MAX(uuid) FROM versions AS newer WHERE newer.key = versions.key AND newer.precedence = versions.precedence)),
+		current_environments AS (SELECT uuid, key, project, stage, value, data_key, key_id, precedence, removed
+			FROM latest
+			WHERE removed != 2)`, len(args)+1)
+		args = append(args, upperBound)
+	}
+
+	query := fmt.Sprintf(`WITH 
+		%s,
+		%s,
+		result AS (SELECT uuid, key, project, stage, value, data_key, key_id, removed
+			FROM current_environments
+			WHERE precedence = (SELECT MIN(precedence) FROM current_environments AS preferred WHERE preferred.key = current_environments.key))
+
+		SELECT * FROM result
+		%s;`, scopes, currentEnvironments, store.orderByKey("key"))
+
+	statement, err := store.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer statement.Close()
+
+	rows, err := statement.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	envs := orderedmap.NewOrderedMap[string, string]()
+	sources := map[string]string{}
+	unreadable := []string{}
+	for rows.Next() {
+		var key string
+		var sealed envelope
+		var removed int
+		err = rows.Scan(&sealed.Binding.Uuid, &key, &sealed.Binding.Project, &sealed.Binding.Stage, &sealed.Value, &sealed.DataKey, &sealed.KeyId, &removed)
+		if err != nil {
+			return nil, nil, err
+		}
+		sealed.Binding.Key = key
+
+		if removed == 1 {
+			return nil, nil, fmt.Errorf("%w: %s was removed with rm", ErrHistoryUnavailable, key)
+		}
+
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "get", "env", key)
+		}
+
+		decrypted, err := openEnvelope(ctx, sealed, store.Keyring())
+		if errors.Is(err, ErrNoIdentity) || errors.Is(err, ErrNoEncryptionKey) {
+			if isDebugEnabled {
+				slog.InfoContext(ctx, "skip", "env", key, "reason", err)
+			}
+
+			unreadable = append(unreadable, key)
+
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "decrypt", "env", key)
+		}
+
+		envs.Set(key, decrypted)
+		sources[key] = sealed.Binding.Project
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(unreadable) > 0 && !store.isUnreadableSkipped {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnreadable, strings.Join(unreadable, ", "))
+	}
+
+	return envs, sources, nil
+}
+
+// renamedSince returns ErrHistoryUnavailable when a key or project of the scopes was renamed with mv after
+// upperBound, mv moves the history with the values so it cannot tell what they were named at the time
+func (store *Store) renamedSince(ctx context.Context, scopes string, args []any, upperBound string) error {
+	var key string
+	var project string
+	err := store.db.QueryRowContext(ctx, fmt.Sprintf(`WITH 
+		%s
+		SELECT tombstones.key, tombstones.project
+		FROM tombstones
+		INNER JOIN scopes
+		ON tombstones.project = scopes.project AND (tombstones.stage = scopes.stage OR tombstones.key = '')
+		WHERE tombstones.op = 'mv' AND tombstones.uuid > $%d
+		LIMIT 1;`, scopes, len(args)+1), append(slices.Clone(args), upperBound)...).Scan(&key, &project)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		return fmt.Errorf("%w: %s was renamed with mv", ErrHistoryUnavailable, project)
+	}
+
+	return fmt.Errorf("%w: %s in %s was renamed with mv", ErrHistoryUnavailable, key, project)
+}
+
+// Delete removes a key from the store scope and returns the keys deleted
+func (store *Store) Delete(ctx context.Context, key string, includeDeprecated bool, includeGlobal bool) ([]string, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	inProjectFilter := ""
+	if includeGlobal {
+		inProjectFilter = "((project = $2 AND stage = $3) OR (project = '*' AND stage = ''))"
+	} else {
+		inProjectFilter = "(project = $2 AND stage = $3)"
+	}
+
+	inDeprecatedFilter := ""
+	if includeDeprecated {
+		inDeprecatedFilter = "(0, 1)"
+	} else {
+		inDeprecatedFilter = "(0)"
+	}
+
+	statement := fmt.Sprintf(`DELETE FROM environments
+		WHERE key = $1
+		AND %s
+		AND deprecated IN %s
+		RETURNING key, project, stage, uuid, deprecated;`, inProjectFilter, inDeprecatedFilter)
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleteEnv, err := tx.PrepareContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer deleteEnv.Close()
+
+	var rows *sql.Rows
+	rows, err = deleteEnv.QueryContext(ctx, key, store.project, store.stage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := []string{}
+	deletedScopes := []scope{}
+	// the current version deleted in each scope, ListAsOf cannot return a value while it was current
+	deletedVersions := map[scope]string{}
+	for rows.Next() {
+		var key string
+		var deletedScope scope
+		var id string
+		var deprecated int
+		err = rows.Scan(&key, &deletedScope.Project, &deletedScope.Stage, &id, &deprecated)
+		if err != nil {
+			return nil, err
+		}
+
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "delete", "env", key)
+		}
+
+		deleted = append(deleted, key)
+
+		if !slices.Contains(deletedScopes, deletedScope) {
+			deletedScopes = append(deletedScopes, deletedScope)
+		}
+
+		if deprecated == 0 {
+			deletedVersions[deletedScope] = id
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, deletedScope := range deletedScopes {
+		err = recordTombstone(ctx, tx, "rm", key, deletedScope.Project, deletedScope.Stage, deletedVersions[deletedScope])
+		if err != nil {
+			return nil, err
+		}
+
+		err = store.recordChange(ctx, tx, "rm", key, "", deletedScope.Project, deletedScope.Stage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "delete", "env", key, "project", store.project, "stage", store.stage, "includeGlobal", includeGlobal)
+	}
+
+	return deleted, nil
+}
+
+// recordTombstone keeps when a key was removed with rm, version being the current version it deleted, or
+// renamed with mv, key being empty for a project, so ListAsOf does not return the versions left behind as current
+func recordTombstone(ctx context.Context, tx *sql.Tx, op string, key string, project string, stage string, version string) error {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tombstones(uuid, op, key, project, stage, version)
+		VALUES($1, $2, $3, $4, $5, $6);`, id.String(), op, key, project, stage, version)
+
+	return err
+}
+
+// Set writes a new version of key, deprecating the current one
+func (store *Store) Set(ctx context.Context, key string, value string, isGlobal bool) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	project, stage := store.writeScope(isGlobal)
+
+	err = store.setEnv(ctx, tx, key, value, project, stage)
+	if err != nil {
+		return err
+	}
+
+	err = store.recordChange(ctx, tx, "set", key, "", project, stage)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rollback restores a previous version, the latest deprecated one when to is empty
+func (store *Store) Rollback(ctx context.Context, key string, to string, isGlobal bool) error {
+	_, _, err := store.rollback(ctx, key, to, isGlobal)
+
+	return err
+}
+
+// rollback returns the restored value, restored is false when the target is already current
+func (store *Store) rollback(ctx context.Context, key string, to string, isGlobal bool) (string, bool, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	project, stage := store.writeScope(isGlobal)
+
+	history, err := tx.PrepareContext(ctx, `SELECT uuid, value, data_key, key_id, deprecated
+		FROM environments
+		WHERE key = $1 AND project = $2 AND stage = $3
+		ORDER BY uuid DESC;`)
+	if err != nil {
+		return "", false, err
+	}
+	defer history.Close()
+
+	rows, err := history.QueryContext(ctx, key, project, stage)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	type version struct {
+		uuid       string
+		sealed     envelope
+		deprecated bool
+	}
+
+	versions := []version{}
+	for rows.Next() {
+		var id string
+		var sealed envelope
+		var deprecated int
+		err = rows.Scan(&id, &sealed.Value, &sealed.DataKey, &sealed.KeyId, &deprecated)
+		if err != nil {
+			return "", false, err
+		}
+		sealed.Binding = binding{Uuid: id, Key: key, Project: project, Stage: stage}
+
+		versions = append(versions, version{
+			uuid:       id,
+			sealed:     sealed,
+			deprecated: deprecated == 1,
+		})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return "", false, err
+	}
+	rows.Close()
+
+	target := -1
+	number, numberErr := strconv.Atoi(to)
+	for i, version := range versions {
+		if to == "" && version.deprecated {
+			target = i
+		} else if to != "" && (version.uuid == to || (numberErr == nil && len(versions)-i == number)) {
+			target = i
+		}
+
+		if target != -1 {
+			break
+		}
+	}
+
+	if target == -1 {
+		if to == "" {
+			to = "previous"
+		}
+
+		return "", false, fmt.Errorf("%w: %s of %s", ErrVersionNotFound, to, key)
+	}
+
+	if !versions[target].deprecated {
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "rollback", "env", key, "project", project, "current", versions[target].uuid)
+		}
+
+		return "", false, nil
+	}
+
+	value, err := openEnvelope(ctx, versions[target].sealed, store.Keyring())
+	if err != nil {
+		return "", false, err
+	}
+
+	err = store.setEnv(ctx, tx, key, value, project, stage)
+	if err != nil {
+		return "", false, err
+	}
+
+	err = store.recordChange(ctx, tx, "set", key, "", project, stage)
+	if err != nil {
+		return "", false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", false, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "rollback", "env", key, "project", project, "stage", stage, "restored", versions[target].uuid)
+	}
+
+	return value, true, nil
+}
+
+// setEnv deprecates the current version of a key and inserts the next one
+func (store *Store) setEnv(ctx context.Context, tx *sql.Tx, key string, value string, project string, stage string) error {
+	isDebugEnabled := isDebug(ctx)
+
+	deprecate, err := tx.PrepareContext(ctx, "UPDATE environments SET deprecated = 1 WHERE key = $1 AND project = $2 AND stage = $3;")
+	if err != nil {
+		return err
+	}
+	defer deprecate.Close()
+
+	result, err := deprecate.ExecContext(ctx, key, project, stage)
+	if err != nil {
+		return err
+	}
+
+	if isDebugEnabled {
+		rowsAffected, _ := result.RowsAffected()
+
+		slog.InfoContext(ctx, "deprecated", "affected", rowsAffected)
+		slog.InfoContext(ctx, "deprecate", "env", key, "project", project, "stage", stage)
+	}
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO environments(uuid, key, value, data_key, key_id, project, stage, deprecated, author, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, 0, $8, $9);`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	recipients, err := Recipients(ctx, tx, project)
+	if err != nil {
+		return err
+	}
+
+	uuid, _ := uuid.NewV7()
+	sealed, err := sealEnvelope(ctx, value, store.Keyring(), recipients, binding{Uuid: uuid.String(), Key: key, Project: project, Stage: stage})
+	if err != nil {
+		return err
+	}
+	result, err = insert.ExecContext(ctx, uuid, key, sealed.Value, sealed.DataKey, sealed.KeyId, project, stage, store.author, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if isDebugEnabled {
+		rowsAffected, _ := result.RowsAffected()
+
+		slog.InfoContext(ctx, "insert", "affected", rowsAffected)
+		slog.InfoContext(ctx, "insert", "env", key, "project", project, "stage", stage)
+	}
+
+	return nil
+}
+
+// writeScope is where writes land, global values are never staged
+func (store *Store) writeScope(isGlobal bool) (string, string) {
+	if isGlobal {
+		return "*", ""
+	}
+
+	return store.project, store.stage
+}
+
+// Rename moves a key, or a whole project when isProject, and reseals the values it moves
+func (store *Store) Rename(ctx context.Context, previous string, next string, isGlobal bool, isProject bool) error {
+	isDebugEnabled := isDebug(ctx)
+
+	projectStatement := "UPDATE environments SET project = $1 WHERE project = $2 AND project != '*';"
+	environmentStatement := "UPDATE environments SET key = $1 WHERE key = $2 AND project = $3 AND stage = $4;"
+
+	project, stage := store.writeScope(isGlobal)
+
+	statement := ""
+	args := []any{next, previous}
+	if isProject {
+		statement = projectStatement
+	} else {
+		statement = environmentStatement
+		args = append(args, project, stage)
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	boundStatement := "SELECT uuid, key, project, stage, value, data_key, key_id FROM environments WHERE project = $1 AND project != '*';"
+	boundArgs := []any{previous}
+	if !isProject {
+		boundStatement = "SELECT uuid, key, project, stage, value, data_key, key_id FROM environments WHERE key = $1 AND project = $2 AND stage = $3;"
+		boundArgs = append(boundArgs, project, stage)
+	}
+
+	bound, err := boundRows(ctx, tx, boundStatement, boundArgs...)
+	if err != nil {
+		return err
+	}
+
+	mv, err := tx.PrepareContext(ctx, statement)
+	if err != nil {
+		return err
+	}
+	defer mv.Close()
+
+	result, err := mv.ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if isProject {
+		inheritanceStatements := []string{
+			"UPDATE projects SET name = $1 WHERE name = $2;",
+			"UPDATE projects SET parent = $1 WHERE parent = $2;",
+			"UPDATE recipients SET project = $1 WHERE project = $2;",
+			"UPDATE tombstones SET project = $1 WHERE project = $2;",
+		}
+
+		for _, statement := range inheritanceStatements {
+			_, err = tx.ExecContext(ctx, statement, next, previous)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	skipped, err := rebind(ctx, tx, store.Keyring(), bound, func(bound binding) binding {
+		if isProject {
+			bound.Project = next
+		} else {
+			bound.Key = next
+		}
+
+		return bound
+	})
+	if err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		return fmt.Errorf("%w: %d values could not be sealed to the new name", ErrNoIdentity, skipped)
+	}
+
+	// the history moved with the values, ListAsOf refuses a time before the mv rather than return it under the new name
+	if isProject {
+		err = recordTombstone(ctx, tx, "mv", "", next, "", "")
+	} else if moved > 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE tombstones SET key = $1 WHERE key = $2 AND project = $3 AND stage = $4;", next, previous, project, stage)
+		if err != nil {
+			return err
+		}
+
+		err = recordTombstone(ctx, tx, "mv", next, project, stage, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	if isProject {
+		err = store.recordChange(ctx, tx, "mv", "", next, previous, "")
+	} else if moved > 0 {
+		err = store.recordChange(ctx, tx, "mv", previous, next, project, stage)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "rename", "previous", previous, "next", next, "isProject", isProject, "stage", stage)
+	}
+
+	return nil
+}
+
+// Prune removes deprecated versions past offset and returns how many were removed, current values are kept.
+// Changes past CHANGES_RETENTION are trimmed with them
+func (store *Store) Prune(ctx context.Context, offset int, withGlobal bool) (int, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	prune, err := tx.PrepareContext(ctx, `DELETE FROM environments 
+		WHERE uuid 
+		IN (
+			SELECT uuid 
+			FROM environments 
+			WHERE project = $1 AND stage = $2 AND deprecated = 1
+			ORDER BY uuid DESC
+			LIMIT $3
+			OFFSET $4);`)
+	if err != nil {
+		return 0, err
+	}
+	defer prune.Close()
+
+	project, stage := store.writeScope(withGlobal)
+
+	var result sql.Result
+	if store.driver == "sqlite3" {
+		result, err = prune.ExecContext(ctx, project, stage, "-1", offset)
+		if err != nil {
+			return 0, err
+		}
+	} else if store.driver == "pgx" {
+		result, err = prune.ExecContext(ctx, project, stage, nil, offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = trimChanges(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if pruned > 0 {
+		err = store.recordChange(ctx, tx, "prune", "", "", project, stage)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "prune", "offset", offset, "project", store.project, "stage", store.stage, "withGlobal", withGlobal, "pruned", pruned)
+	}
+
+	return int(pruned), nil
+}
+
+// Clear removes every version past offset and returns their keys, changes are trimmed like Prune
+func (store *Store) Clear(ctx context.Context, offset int, withGlobal bool) ([]string, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	prune, err := tx.PrepareContext(ctx, `DELETE FROM environments 
+		WHERE uuid
+		IN (
+			SELECT uuid
+			FROM environments
+			WHERE project = $1 AND stage = $2
+			ORDER BY uuid DESC
+			LIMIT $3
+			OFFSET $4)
+		RETURNING key;`)
+	if err != nil {
+		return nil, err
+	}
+	defer prune.Close()
+
+	project, stage := store.writeScope(withGlobal)
+
+	var rows *sql.Rows
+	if store.driver == "sqlite3" {
+		rows, err = prune.QueryContext(ctx, project, stage, "-1", offset)
+		if err != nil {
+			return nil, err
+		}
+	} else if store.driver == "pgx" {
+		rows, err = prune.QueryContext(ctx, project, stage, nil, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	deleted := []string{}
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		if isDebugEnabled {
+			slog.InfoContext(ctx, "clear", "env", key)
+		}
+
+		deleted = append(deleted, key)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = trimChanges(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deleted) > 0 {
+		err = store.recordChange(ctx, tx, "prune", "", "", project, stage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "clear", "offset", offset, "project", store.project, "stage", store.stage, "withGlobal", withGlobal)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,153 @@
+package kryptos
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+const TOKEN_PREFIX = "kryptos_"
+
+// Token grants API access to one project, a token for * can write global values and rename projects.
+// Only a hash of the token is stored
+type Token struct {
+	Project    string
+	Name       string
+	IsReadOnly bool
+	CreatedAt  time.Time
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken returns a new token for the project, creating a token with the name of an existing one replaces it
+func CreateToken(ctx context.Context, db *sql.DB, project string, name string, isReadOnly bool) (string, error) {
+	isDebugEnabled := isDebug(ctx)
+
+	err := authorizeAll(ctx, db, PERMISSION_ADMIN, project)
+	if err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	token := TOKEN_PREFIX + hex.EncodeToString(secret)
+
+	readOnly := 0
+	if isReadOnly {
+		readOnly = 1
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO tokens(project, name, hash, read_only, created_at)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT(project, name) DO UPDATE SET hash = excluded.hash, read_only = excluded.read_only, created_at = excluded.created_at;`,
+		project, name, hashToken(token), readOnly, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "token", "project", project, "name", name, "readOnly", isReadOnly)
+	}
+
+	return token, nil
+}
+
+// Tokens lists the tokens of a project without the tokens themselves
+func Tokens(ctx context.Context, db queryer, project string) ([]Token, error) {
+	rows, err := db.QueryContext(ctx, "SELECT project, name, read_only, created_at FROM tokens WHERE project = $1 ORDER BY name;", project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		var token Token
+		var readOnly int
+		var createdAt sql.NullTime
+		err = rows.Scan(&token.Project, &token.Name, &readOnly, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		token.IsReadOnly = readOnly == 1
+		token.CreatedAt = createdAt.Time
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func RemoveToken(ctx context.Context, db *sql.DB, project string, name string) error {
+	isDebugEnabled := isDebug(ctx)
+
+	err := authorizeAll(ctx, db, PERMISSION_ADMIN, project)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, "DELETE FROM tokens WHERE project = $1 AND name = $2;", project, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+	}
+
+	if isDebugEnabled {
+		slog.InfoContext(ctx, "token", "project", project, "removed", name)
+	}
+
+	return nil
+}
+
+// Authenticate returns the token a bearer token was created as
+func Authenticate(ctx context.Context, db queryer, token string) (Token, error) {
+	if !strings.HasPrefix(token, TOKEN_PREFIX) {
+		return Token{}, ErrInvalidToken
+	}
+
+	var authenticated Token
+	var readOnly int
+	var createdAt sql.NullTime
+	err := db.QueryRowContext(ctx, "SELECT project, name, read_only, created_at FROM tokens WHERE hash = $1;", hashToken(token)).
+		Scan(&authenticated.Project, &authenticated.Name, &readOnly, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Token{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	authenticated.IsReadOnly = readOnly == 1
+	authenticated.CreatedAt = createdAt.Time
+
+	return authenticated, nil
+}
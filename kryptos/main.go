@@ -2,23 +2,60 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"skulpture/kryptos/commands"
 	"skulpture/kryptos/kryptos"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/docopt/docopt-go"
 	"github.com/dogmatiq/ferrite"
 	"github.com/joho/godotenv"
 	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-shellwords"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
 	ENV_FILE_PATH = ferrite.
-		String("ENV_FILE_PATH", "Load environment from file at path").
+		String(kryptos.ENV_FILE_PATH_ENV, "Load environment from file at path").
 		Optional()
 )
 
+// environ is the environment before any value is set, run passes it on without kryptos.CONFIG_ENVS
+// so values from another project, keys and tokens are not leaked to the command
+var environ = []string{}
+
+// agentReads are the commands a running agent answers, they only read current values
+var agentReads = []string{"cat", "grep", "run", "dump", "info", "stat"}
+
+// isAgentRead tells whether the command line only needs what a running agent answers,
+// values as of a timestamp are decrypted from the database
+func isAgentRead(args []string) bool {
+	if len(args) > 1 && args[0] == "agent" && args[1] == "lock" {
+		return true
+	}
+
+	if len(args) == 0 || !slices.Contains(agentReads, args[0]) {
+		return false
+	}
+
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+
+		if strings.HasPrefix(arg, "--as-of") {
+			return false
+		}
+	}
+
+	return true
+}
+
 func init() {
 	promptEnvs := func() {
 		if os.Getenv(kryptos.PROJECT_ENV) == "" {
@@ -40,6 +77,7 @@ func init() {
 				Items: []string{
 					"sqlite3",
 					"pgx",
+					"http",
 				},
 			}
 
@@ -64,17 +102,50 @@ func init() {
 			os.Setenv(kryptos.DB_CONNECTION_STRING_ENV, result)
 		}
 
-		if os.Getenv(kryptos.ENCRYPTION_KEY_ENV) == "" {
-			prompt := promptui.Prompt{
-				Label: "Encryption key",
+		isKeyProviderSet := os.Getenv(kryptos.KEY_PROVIDER_ENV) != "" && os.Getenv(kryptos.KEY_PROVIDER_ENV) != "env"
+		isUnsealing := len(os.Args) > 1 && os.Args[1] == "unseal"
+		isRemote := os.Getenv(kryptos.DB_DRIVER_ENV) == "http"
+		// a running agent has already been unlocked and answers reads without a key, writes still need one
+		isReadingFromAgent := isAgentRead(os.Args[1:]) && kryptos.IsAgentRunning()
+		if !isKeyProviderSet && !isUnsealing && !isRemote && !isReadingFromAgent && os.Getenv(kryptos.ENCRYPTION_KEY_ENV) == "" && os.Getenv(kryptos.ENCRYPTION_PASSPHRASE_ENV) == "" && os.Getenv(kryptos.IDENTITY_ENV) == "" {
+			modePrompt := promptui.Select{
+				Label: "Encryption",
+				Items: []string{
+					"Passphrase",
+					"Encryption key",
+					"Identity",
+					"None, write to projects with recipients only",
+				},
 			}
 
-			result, err := prompt.Run()
+			index, mode, err := modePrompt.Run()
 			if err != nil {
 				panic(err)
 			}
 
-			os.Setenv(kryptos.ENCRYPTION_KEY_ENV, result)
+			if index == 3 {
+				mode = ""
+			}
+
+			if mode != "" {
+				prompt := promptui.Prompt{
+					Label: mode,
+					Mask:  '*',
+				}
+
+				result, err := prompt.Run()
+				if err != nil {
+					panic(err)
+				}
+
+				modeEnvs := map[string]string{
+					"Passphrase":     kryptos.ENCRYPTION_PASSPHRASE_ENV,
+					"Encryption key": kryptos.ENCRYPTION_KEY_ENV,
+					"Identity":       kryptos.IDENTITY_ENV,
+				}
+
+				os.Setenv(modeEnvs[mode], result)
+			}
 		}
 	}
 
@@ -94,6 +165,8 @@ func init() {
 		panic(err)
 	}
 
+	environ = os.Environ()
+
 	for key, value := range kryptos.ENVS.Iterator() {
 		os.Setenv(key, value)
 	}
@@ -115,16 +188,37 @@ func main() {
 	usage := `Kryptos
 
 Usage:
-    kryptos set <key> <value> [-d | --debug] [-g | --global]
-    kryptos mv <previous> <next> [-p | --project] [-g | --global]
-    kryptos rm <key> [-d | --debug] [-a | --all] [-g | --global]
-    kryptos grep <key>
-    kryptos rotate (-e <encryption> | --encryption-key=<encryption>) [-d | --debug]
-    kryptos cat
-    kryptos dump [-o <output> | --output=<output>]
-    kryptos prune <offset> [-d | --debug] [-a | --all] [-g | --global]
-    kryptos info
-    kryptos stat
+    kryptos set <key> <value> [-d | --debug] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos mv <previous> <next> [-p] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos rm <key> [-d | --debug] [-a | --all] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos grep <key> [--as-of=<timestamp>] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos log <key> [-d | --debug] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos rollback <key> [-t <version> | --to=<version>] [-d | --debug] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos rotate (-e <encryption> | --encryption-key=<encryption> | --passphrase) [--batch-size=<size>] [--dry-run] [-d | --debug] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos cat [--as-of=<timestamp>] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos run [--project=<project>] [--watch [--interval=<interval>] [--reload-signal=<signal>] [--grace-period=<period>] | --as-of=<timestamp>] [-s <stage> | --stage=<stage>] [--reason=<reason>] [--] <command>...
+    kryptos dump [-o <output> | --output=<output>] [--as-of=<timestamp>] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos prune <offset> [-d | --debug] [-a | --all] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos diff (<from> <to> | -f <file> | --file=<file>) [--show-values] [--json] [-d | --debug] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos project set-parent <child> <parent> [-d | --debug] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos recipients add <name> <public-key> [-d | --debug] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos recipients rm <name> [-d | --debug] [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos recipients ls [-g | --global] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos recipients keygen [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos keys split --shares=<shares> --threshold=<threshold> [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos unseal [--share-file=<file>]... [--] [<args>...]
+    kryptos serve [--listen=<address>] [--tls-cert=<cert> --tls-key=<key>] [-d | --debug] [--reason=<reason>]
+    kryptos agent [--ttl=<ttl>] [--idle=<idle>] [-d | --debug] [--reason=<reason>]
+    kryptos agent lock [--reason=<reason>]
+    kryptos watch [--project=<project>] [-d | --debug] [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos tokens add <name> [--read-only] [-d | --debug] [-g | --global] [--reason=<reason>]
+    kryptos tokens rm <name> [-d | --debug] [-g | --global] [--reason=<reason>]
+    kryptos tokens ls [-g | --global] [--reason=<reason>]
+    kryptos grant <principal> <permission> [--project=<project>] [--key=<pattern>] [-d | --debug] [-g | --global] [--reason=<reason>]
+    kryptos revoke <principal> <permission> [--project=<project>] [--key=<pattern>] [-d | --debug] [-g | --global] [--reason=<reason>]
+    kryptos audit [--project=<project>] [--since=<since>] [--key=<pattern>] [--actor=<actor>] [--json] [-d | --debug] [--reason=<reason>]
+    kryptos info [-s <stage> | --stage=<stage>] [--reason=<reason>]
+    kryptos stat [-s <stage> | --stage=<stage>] [--reason=<reason>]
     kryptos -h | --help
     kryptos -v | --version
 
@@ -132,24 +226,76 @@ Description:
     Manages environment variables
     Environment variables are encrypted and versioned
 
-    Supported database drivers: sqlite3, postgres
+    Supported database drivers: sqlite3, postgres, http
+    With DB_DRIVER=http, DB_CONNECTION_STRING is the URL of kryptos serve and API_TOKEN,
+    or API_TOKEN in the PROFILE_FILE dotenv file, authenticates with it
+    While kryptos agent runs, values are read from it over AGENT_SOCKET instead of being decrypted
+    When PRINCIPAL is set, its grants are enforced: read, write, admin or rotate on a project and key pattern,
+    values inherited from a parent project or * are read with a grant on the project they are set in
+    Every command is recorded in the append-only audit log with its actor (PRINCIPAL, AUTHOR or the user), host and --reason,
+    as started before it runs, so it fails when it cannot be recorded, then with its outcome (ok, denied or failed)
 
 Command reference:
-    set     Set an environment variable
-    mv      Rename an environment variable or project
-    rm      Remove an environment variable
-    grep    Get the value of an environment variable
-    rotate  Change the encryption key used
-    cat     List all environment variables
-    dump    Print all environment variables to a file
-    prune   Delete all environment variables linked to a project
-    info    Kryptos information
-    stat    Environment variable information
+    set         Set an environment variable
+    mv          Rename an environment variable or project
+    rm          Remove an environment variable
+    grep        Get the value of an environment variable
+    log         List every version of an environment variable
+    rollback    Restore a previous version of an environment variable
+    rotate      Change the encryption key used, data keys are re-wrapped in resumable batches.
+                With KEY_PROVIDER set the key is rotated in the provider instead
+    cat         List all environment variables
+    run         Run a command with the environment variables set, exits with the command's exit code.
+                With --watch the command is restarted, or signalled, when a variable changes
+    dump        Print all environment variables to a file
+    prune       Delete all environment variables linked to a project
+    diff        Compare the environment variables of two projects, stages (<project>:<stage>) or a dotenv file
+    project     Manage project inheritance, a parent of * only inherits global variables
+    recipients  Manage the public keys values in a project are sealed to, only IDENTITY holders can read them
+    keys        Split the encryption key into Shamir shares
+    unseal      Rebuild the encryption key from shares for one command, or a session when none is given
+    serve       Serve the environment variables as a JSON API, requests are authenticated with tokens
+    agent       Keep decrypted environment variables in memory for other invocations until locked or idle
+    watch       Print changes (set, rm, mv, prune, rotate, recipients, set-parent) to a project as JSON lines,
+                a project of * sees every change
+    tokens      Manage the API tokens of a project, a token for * (--global) can change global variables
+    grant       Allow a principal a permission on the keys of a project, a grant on * (--global) covers every project
+    revoke      Remove a grant
+    audit       List the audit log of a project oldest first, a project of * lists every project
+    info        Kryptos information
+    stat        Environment variable information
 
 Options:
     -o --output=<output>              Output file [default: ./.env]
     -e --encryption-key=<encryption>  Encryption key
-    -p --project                      Project
+    --passphrase                      Prompt for a passphrase to derive the encryption key from
+    --project=<project>               Project to run the command in, watch, grant on or audit, overrides PROJECT
+    --watch                           Poll for changes while the command runs
+    --interval=<interval>             Time between polls [default: 30s]
+    --reload-signal=<signal>          Signal the command instead of restarting it, such as HUP
+    --grace-period=<period>           Time a restarted command has to exit before it is killed [default: 10s]
+    --as-of=<timestamp>               Read values as they were at an RFC3339 timestamp
+    --batch-size=<size>               Rows re-wrapped per transaction [default: 100]
+    --dry-run                         Check every row can be rotated without writing
+    --shares=<shares>                 Number of shares to split the encryption key into
+    --threshold=<threshold>           Number of shares needed to rebuild the encryption key
+    --share-file=<file>               File containing a share, shares not read from files are prompted for
+    --listen=<address>                Address to serve the API on, all interfaces with :8200 [default: 127.0.0.1:8200]
+    --tls-cert=<cert>                 PEM certificate file to serve the API over TLS with
+    --tls-key=<key>                   PEM private key file of the certificate
+    --ttl=<ttl>                       Time the agent keeps values before reading them again [default: 5m]
+    --idle=<idle>                     Time without a request after which the agent locks [default: 30m]
+    --read-only                       Token can only read environment variables
+    --key=<pattern>                   Keys the grant covers or the audit log is filtered by, such as DB_* [default: *]
+    --since=<since>                   Audit entries since an RFC3339 timestamp or a duration ago, such as 24h
+    --actor=<actor>                   Audit entries of an actor
+    --reason=<reason>                 Why the command is run, recorded in the audit log
+    -t --to=<version>                 Version number or uuid to restore, defaults to the previous version
+    -f --file=<file>                  Dotenv file to compare against
+    --show-values                     Show values instead of fingerprints
+    --json                            Print machine-readable output
+    -s --stage=<stage>                Stage within the project, overrides STAGE
+    -p                                Rename a project rather than an environment variable
     -d --debug                        Enable debug logs [default: false]
     -a --all                          Include current variables
     -g --global                       Include global variables [default: false]
@@ -163,8 +309,56 @@ Options:
 		panic(err)
 	}
 
+	unseal, _ := options.Bool("unseal")
+	if unseal {
+		shareFiles, _ := options["--share-file"].([]string)
+		args, _ := options["<args>"].([]string)
+
+		unsealCommand := commands.Unseal{
+			Shares: promptShares(shareFiles),
+			View:   os.Stderr,
+		}
+
+		err = unsealCommand.Execute(context.WithValue(context.Background(), kryptos.ContextKeyDebug, false))
+		if err != nil {
+			panic(err)
+		}
+
+		if len(args) == 0 {
+			session(usage)
+
+			return
+		}
+
+		options, err = docopt.ParseArgs(usage, args, kryptos.VERSION)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	execute(options)
+}
+
+func execute(options docopt.Opts) {
 	debug, _ := options.Bool("--debug")
+
+	// the overrides only last for this command, a session runs the next one in its own scope
+	stage, _ := options.String("--stage")
+	if stage != "" {
+		previous := kryptos.SetStage(stage)
+		defer kryptos.SetStage(previous)
+	}
+
+	projectOption, _ := options.String("--project")
+	if projectOption != "" {
+		previous := kryptos.SetProject(projectOption)
+		defer kryptos.SetProject(previous)
+	}
+
+	reason, _ := options.String("--reason")
+
 	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, debug)
+	ctx = context.WithValue(ctx, kryptos.ContextKeyReason, reason)
 
 	db, close, err := kryptos.Open(ctx)
 	if err != nil {
@@ -172,23 +366,233 @@ Options:
 	}
 	defer close()
 
+	// the command is recorded before it runs so it has no effect or output when the audit log cannot be written,
+	// its outcome is recorded once it has run, commands fail by panicking
+	auditOp, auditKey := operation(options), operationKey(options)
+	err = kryptos.Audit(ctx, db, auditOp, auditKey)
+	if err != nil {
+		panic(err)
+	}
+
+	recordAudit := func(err error) error {
+		return kryptos.AuditResult(ctx, db, auditOp, auditKey, err)
+	}
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			err := recordAudit(nil)
+			if err != nil {
+				panic(err)
+			}
+
+			return
+		}
+
+		err, ok := recovered.(error)
+		if !ok {
+			err = fmt.Errorf("%v", recovered)
+		}
+
+		// the failure of the command is reported over a failure to record it
+		recordAudit(err)
+
+		panic(recovered)
+	}()
+
 	err = kryptos.GetEnvs(ctx, db)
 	if err != nil {
 		panic(err)
 	}
 
+	asOf, _ := options.String("--as-of")
+	if asOf != "" {
+		timestamp, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			panic(err)
+		}
+
+		err = kryptos.GetEnvsAsOf(ctx, db, timestamp)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	set, _ := options.Bool("set")
 	mv, _ := options.Bool("mv")
+	rollback, _ := options.Bool("rollback")
 	rm, _ := options.Bool("rm")
 	grep, _ := options.Bool("grep")
+	log, _ := options.Bool("log")
 	rotate, _ := options.Bool("rotate")
 	cat, _ := options.Bool("cat")
+	run, _ := options.Bool("run")
 	dump, _ := options.Bool("dump")
 	prune, _ := options.Bool("prune")
+	diff, _ := options.Bool("diff")
+	project, _ := options.Bool("project")
+	recipients, _ := options.Bool("recipients")
+	keys, _ := options.Bool("keys")
+	serve, _ := options.Bool("serve")
+	agent, _ := options.Bool("agent")
+	watch, _ := options.Bool("watch")
+	tokens, _ := options.Bool("tokens")
+	grant, _ := options.Bool("grant")
+	revoke, _ := options.Bool("revoke")
+	audit, _ := options.Bool("audit")
 	info, _ := options.Bool("info")
 	stat, _ := options.Bool("stat")
 
-	if set {
+	if kryptos.IsRemote() && (rotate || project || recipients || tokens || grant || revoke || audit || serve || agent || watch) {
+		panic(kryptos.ErrRemoteUnsupported)
+	}
+
+	if recipients {
+		add, _ := options.Bool("add")
+		ls, _ := options.Bool("ls")
+		keygen, _ := options.Bool("keygen")
+		name, _ := options.String("<name>")
+		isGlobal, _ := options.Bool("--global")
+
+		if add {
+			publicKey, _ := options.String("<public-key>")
+
+			recipientsAddCommand := commands.RecipientsAdd{
+				Db:        db,
+				Name:      name,
+				PublicKey: publicKey,
+				IsGlobal:  isGlobal,
+				View:      os.Stdout,
+			}
+
+			err = recipientsAddCommand.Execute(ctx)
+		} else if ls {
+			recipientsLsCommand := commands.RecipientsLs{
+				Db:       db,
+				IsGlobal: isGlobal,
+				View:     os.Stdout,
+			}
+
+			err = recipientsLsCommand.Execute(ctx)
+		} else if keygen {
+			recipientsKeygenCommand := commands.RecipientsKeygen{
+				View: os.Stdout,
+			}
+
+			err = recipientsKeygenCommand.Execute(ctx)
+		} else {
+			recipientsRmCommand := commands.RecipientsRm{
+				Db:       db,
+				Name:     name,
+				IsGlobal: isGlobal,
+				View:     os.Stdout,
+			}
+
+			err = recipientsRmCommand.Execute(ctx)
+		}
+		if err != nil {
+			panic(err)
+		}
+	} else if tokens {
+		add, _ := options.Bool("add")
+		ls, _ := options.Bool("ls")
+		name, _ := options.String("<name>")
+		isGlobal, _ := options.Bool("--global")
+
+		if add {
+			isReadOnly, _ := options.Bool("--read-only")
+
+			tokensAddCommand := commands.TokensAdd{
+				Db:         db,
+				Name:       name,
+				IsReadOnly: isReadOnly,
+				IsGlobal:   isGlobal,
+				View:       os.Stdout,
+			}
+
+			err = tokensAddCommand.Execute(ctx)
+		} else if ls {
+			tokensLsCommand := commands.TokensLs{
+				Db:       db,
+				IsGlobal: isGlobal,
+				View:     os.Stdout,
+			}
+
+			err = tokensLsCommand.Execute(ctx)
+		} else {
+			tokensRmCommand := commands.TokensRm{
+				Db:       db,
+				Name:     name,
+				IsGlobal: isGlobal,
+			}
+
+			err = tokensRmCommand.Execute(ctx)
+		}
+		if err != nil {
+			panic(err)
+		}
+	} else if grant || revoke {
+		principal, _ := options.String("<principal>")
+		permission, _ := options.String("<permission>")
+		pattern, _ := options.String("--key")
+		isGlobal, _ := options.Bool("--global")
+
+		if grant {
+			grantCommand := commands.Grant{
+				Db:         db,
+				Principal:  principal,
+				Permission: permission,
+				Pattern:    pattern,
+				IsGlobal:   isGlobal,
+			}
+
+			err = grantCommand.Execute(ctx)
+		} else {
+			revokeCommand := commands.Revoke{
+				Db:         db,
+				Principal:  principal,
+				Permission: permission,
+				Pattern:    pattern,
+				IsGlobal:   isGlobal,
+			}
+
+			err = revokeCommand.Execute(ctx)
+		}
+		if err != nil {
+			panic(err)
+		}
+	} else if audit {
+		key, _ := options.String("--key")
+		actor, _ := options.String("--actor")
+		isJson, _ := options.Bool("--json")
+
+		since := time.Time{}
+		sinceOption, _ := options.String("--since")
+		if sinceOption != "" {
+			since, err = time.Parse(time.RFC3339, sinceOption)
+			if err != nil {
+				ago, durationErr := time.ParseDuration(sinceOption)
+				if durationErr != nil {
+					panic(err)
+				}
+
+				since = time.Now().Add(-ago)
+			}
+		}
+
+		auditCommand := commands.Audit{
+			Db:     db,
+			Since:  since,
+			Key:    key,
+			Actor:  actor,
+			IsJson: isJson,
+			View:   os.Stdout,
+		}
+
+		err = auditCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
+	} else if set {
 		key, _ := options.String("<key>")
 		value, _ := options.String("<value>")
 		isGlobal, _ := options.Bool("--global")
@@ -207,7 +611,7 @@ Options:
 	} else if mv {
 		previous, _ := options.String("<previous>")
 		next, _ := options.String("<next>")
-		isProject, _ := options.Bool("--project")
+		isProject, _ := options.Bool("-p")
 		isGlobal, _ := options.Bool("--global")
 
 		mvCommand := commands.Mv{
@@ -222,6 +626,22 @@ Options:
 		if err != nil {
 			panic(err)
 		}
+	} else if rollback {
+		key, _ := options.String("<key>")
+		to, _ := options.String("--to")
+		isGlobal, _ := options.Bool("--global")
+
+		rollbackCommand := commands.Rollback{
+			Db:       db,
+			Key:      key,
+			To:       to,
+			IsGlobal: isGlobal,
+		}
+
+		err = rollbackCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
 	} else if rm {
 		key, _ := options.String("<key>")
 		includeDeprecated, _ := options.Bool("--all")
@@ -250,12 +670,39 @@ Options:
 		if err != nil {
 			panic(err)
 		}
+	} else if log {
+		key, _ := options.String("<key>")
+		isGlobal, _ := options.Bool("--global")
+
+		logCommand := commands.Log{
+			Db:       db,
+			Key:      key,
+			IsGlobal: isGlobal,
+			View:     os.Stdout,
+		}
+
+		err = logCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
 	} else if rotate {
 		encryptionKey, _ := options.String("--encryption-key")
+		batchSize, _ := options.Int("--batch-size")
+		isDryRun, _ := options.Bool("--dry-run")
+		isPassphrase, _ := options.Bool("--passphrase")
+
+		passphrase := ""
+		if isPassphrase {
+			passphrase = promptPassphrase()
+		}
 
 		rotateCommand := commands.Rotate{
 			Db:            db,
 			EncryptionKey: encryptionKey,
+			Passphrase:    passphrase,
+			BatchSize:     batchSize,
+			IsDryRun:      isDryRun,
+			View:          os.Stdout,
 		}
 
 		err = rotateCommand.Execute(ctx)
@@ -271,6 +718,51 @@ Options:
 		if err != nil {
 			panic(err)
 		}
+	} else if run {
+		command, _ := options["<command>"].([]string)
+		isWatching, _ := options.Bool("--watch")
+		interval, _ := options.String("--interval")
+		reloadSignal, _ := options.String("--reload-signal")
+		gracePeriod, _ := options.String("--grace-period")
+
+		runCommand := commands.Run{
+			Db:         db,
+			Command:    command,
+			Environ:    environ,
+			Stdin:      os.Stdin,
+			Stdout:     os.Stdout,
+			Stderr:     os.Stderr,
+			IsWatching: isWatching,
+		}
+
+		runCommand.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			panic(err)
+		}
+
+		runCommand.GracePeriod, err = time.ParseDuration(gracePeriod)
+		if err != nil {
+			panic(err)
+		}
+
+		if reloadSignal != "" {
+			runCommand.ReloadSignal, err = commands.ParseSignal(reloadSignal)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		err = runCommand.Execute(ctx)
+		if code, ok := commands.ExitCode(err); ok {
+			err = recordAudit(nil)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			close()
+			os.Exit(code)
+		} else if err != nil {
+			panic(err)
+		}
 	} else if dump {
 		path, _ := options.String("--output")
 
@@ -304,8 +796,126 @@ Options:
 		if err != nil {
 			panic(err)
 		}
+	} else if diff {
+		from, _ := options.String("<from>")
+		to, _ := options.String("<to>")
+		file, _ := options.String("--file")
+		showValues, _ := options.Bool("--show-values")
+		isJson, _ := options.Bool("--json")
+
+		diffCommand := commands.Diff{
+			Db:         db,
+			From:       from,
+			To:         to,
+			File:       file,
+			ShowValues: showValues,
+			IsJson:     isJson,
+			View:       os.Stdout,
+		}
+
+		err = diffCommand.Execute(ctx)
+		if errors.Is(err, commands.ErrDifferent) {
+			err = recordAudit(nil)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			close()
+			os.Exit(1)
+		} else if err != nil {
+			panic(err)
+		}
+	} else if project {
+		child, _ := options.String("<child>")
+		parent, _ := options.String("<parent>")
+
+		projectSetParentCommand := commands.ProjectSetParent{
+			Db:      db,
+			Project: child,
+			Parent:  parent,
+		}
+
+		err = projectSetParentCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
+	} else if keys {
+		shares, _ := options.Int("--shares")
+		threshold, _ := options.Int("--threshold")
+
+		keysSplitCommand := commands.KeysSplit{
+			Shares:    shares,
+			Threshold: threshold,
+			View:      os.Stdout,
+		}
+
+		err = keysSplitCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
+	} else if agent {
+		lock, _ := options.Bool("lock")
+
+		if lock {
+			agentLockCommand := commands.AgentLock{
+				View: os.Stdout,
+			}
+
+			err = agentLockCommand.Execute(ctx)
+		} else {
+			ttl, _ := options.String("--ttl")
+			idle, _ := options.String("--idle")
+
+			agentCommand := commands.Agent{
+				Db:     db,
+				Socket: kryptos.AgentSocket(),
+				View:   os.Stdout,
+			}
+
+			agentCommand.Ttl, err = time.ParseDuration(ttl)
+			if err != nil {
+				panic(err)
+			}
+
+			agentCommand.Idle, err = time.ParseDuration(idle)
+			if err != nil {
+				panic(err)
+			}
+
+			err = agentCommand.Execute(ctx)
+		}
+		if err != nil {
+			panic(err)
+		}
+	} else if watch {
+		watchCommand := commands.Watch{
+			Db:   db,
+			View: os.Stdout,
+		}
+
+		err = watchCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
+	} else if serve {
+		listen, _ := options.String("--listen")
+		tlsCert, _ := options.String("--tls-cert")
+		tlsKey, _ := options.String("--tls-key")
+
+		serveCommand := commands.Serve{
+			Db:      db,
+			Listen:  listen,
+			TlsCert: tlsCert,
+			TlsKey:  tlsKey,
+			View:    os.Stdout,
+		}
+
+		err = serveCommand.Execute(ctx)
+		if err != nil {
+			panic(err)
+		}
 	} else if info {
 		infoCommand := commands.Info{
+			Db:   db,
 			View: os.Stdout,
 		}
 
@@ -325,3 +935,166 @@ Options:
 		}
 	}
 }
+
+func promptPassphrase() string {
+	prompt := promptui.Prompt{
+		Label: "New passphrase",
+		Mask:  '*',
+	}
+
+	passphrase, err := prompt.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	confirm := promptui.Prompt{
+		Label: "Confirm passphrase",
+		Mask:  '*',
+		Validate: func(input string) error {
+			if input != passphrase {
+				return errors.New("passphrases do not match")
+			}
+
+			return nil
+		},
+	}
+
+	_, err = confirm.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	return passphrase
+}
+
+// promptShares reads shares from files and prompts for the rest until the threshold is met
+func promptShares(shareFiles []string) []string {
+	shares := []string{}
+	for _, shareFile := range shareFiles {
+		share, err := os.ReadFile(shareFile)
+		if err != nil {
+			panic(err)
+		}
+
+		shares = append(shares, strings.TrimSpace(string(share)))
+	}
+
+	for {
+		if len(shares) > 0 {
+			share, err := kryptos.ParseShare(shares[0])
+			if err != nil {
+				panic(err)
+			}
+
+			if len(shares) >= share.Threshold {
+				return shares
+			}
+		}
+
+		prompt := promptui.Prompt{
+			Label: fmt.Sprintf("Share %d", len(shares)+1),
+			Mask:  '*',
+		}
+
+		share, err := prompt.Run()
+		if err != nil {
+			panic(err)
+		}
+
+		shares = append(shares, strings.TrimSpace(share))
+	}
+}
+
+// session runs commands with the unsealed key until exit, the key only lives in this process
+func session(usage string) {
+	parser := &docopt.Parser{
+		HelpHandler: docopt.PrintHelpOnly,
+	}
+
+	for {
+		prompt := promptui.Prompt{
+			Label: "kryptos",
+		}
+
+		line, err := prompt.Run()
+		if err != nil {
+			return
+		}
+
+		// quoted arguments are kept whole so values can contain spaces, variables are not expanded
+		args, err := shellwords.Parse(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+
+			continue
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "exit" {
+			return
+		}
+
+		options, err := parser.ParseArgs(usage, args, kryptos.VERSION)
+		if err != nil {
+			continue
+		}
+
+		unseal, _ := options.Bool("unseal")
+		if unseal {
+			continue
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintln(os.Stderr, r)
+				}
+			}()
+
+			execute(options)
+		}()
+	}
+}
+
+// operation names the command for the audit log, such as grep or tokens add
+func operation(options docopt.Opts) string {
+	for _, parent := range []string{"project", "recipients", "keys", "agent", "tokens"} {
+		isParent, _ := options.Bool(parent)
+		if !isParent {
+			continue
+		}
+
+		for _, subcommand := range []string{"set-parent", "add", "rm", "ls", "keygen", "split", "lock"} {
+			isSubcommand, _ := options.Bool(subcommand)
+			if isSubcommand {
+				return parent + " " + subcommand
+			}
+		}
+
+		return parent
+	}
+
+	for _, command := range []string{"set", "mv", "rm", "grep", "log", "rollback", "rotate", "cat", "run", "dump", "prune", "diff", "serve", "watch", "grant", "revoke", "audit", "info", "stat"} {
+		isCommand, _ := options.Bool(command)
+		if isCommand {
+			return command
+		}
+	}
+
+	return ""
+}
+
+// operationKey is the key, token, recipient, project or principal the command is about
+func operationKey(options docopt.Opts) string {
+	for _, argument := range []string{"<key>", "<previous>", "<name>", "<child>", "<principal>"} {
+		key, _ := options.String(argument)
+		if key != "" {
+			return key
+		}
+	}
+
+	return ""
+}
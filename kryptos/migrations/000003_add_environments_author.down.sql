@@ -0,0 +1,3 @@
+ALTER TABLE environments DROP COLUMN created_at;
+
+ALTER TABLE environments DROP COLUMN author;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE environments ADD COLUMN author TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE environments ADD COLUMN created_at TIMESTAMP;
\ No newline at end of file
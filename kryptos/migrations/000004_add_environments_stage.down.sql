@@ -0,0 +1 @@
+ALTER TABLE environments DROP COLUMN stage;
\ No newline at end of file
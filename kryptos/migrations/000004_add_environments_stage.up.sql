@@ -0,0 +1 @@
+ALTER TABLE environments ADD COLUMN stage TEXT NOT NULL DEFAULT '';
\ No newline at end of file
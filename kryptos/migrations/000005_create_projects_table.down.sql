@@ -0,0 +1 @@
+DROP TABLE IF EXISTS projects;
\ No newline at end of file
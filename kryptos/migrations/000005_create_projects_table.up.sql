@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS projects (
+	name TEXT NOT NULL,
+	parent TEXT NOT NULL,
+	CONSTRAINT pk_project PRIMARY KEY(name)
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE environments DROP COLUMN key_id;
+
+ALTER TABLE environments DROP COLUMN data_key;
\ No newline at end of file
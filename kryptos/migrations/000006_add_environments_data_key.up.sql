@@ -0,0 +1,3 @@
+ALTER TABLE environments ADD COLUMN data_key TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE environments ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
\ No newline at end of file
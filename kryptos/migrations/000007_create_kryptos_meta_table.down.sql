@@ -0,0 +1 @@
+DROP TABLE IF EXISTS kryptos_meta;
\ No newline at end of file
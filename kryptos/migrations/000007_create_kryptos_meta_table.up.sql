@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS kryptos_meta (
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	CONSTRAINT pk_kryptos_meta PRIMARY KEY(name)
+);
\ No newline at end of file
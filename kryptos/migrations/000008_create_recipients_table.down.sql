@@ -0,0 +1 @@
+DROP TABLE IF EXISTS recipients;
\ No newline at end of file
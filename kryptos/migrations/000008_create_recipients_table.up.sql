@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS recipients (
+	project TEXT NOT NULL,
+	name TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	CONSTRAINT pk_recipient PRIMARY KEY(project, name)
+);
\ No newline at end of file
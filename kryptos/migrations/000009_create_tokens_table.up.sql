@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS tokens (
+	project TEXT NOT NULL,
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	read_only INTEGER NOT NULL,
+	created_at TIMESTAMP,
+	CONSTRAINT pk_token PRIMARY KEY(project, name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_hash ON tokens(hash);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS changes (
+	sequence INTEGER NOT NULL,
+	op TEXT NOT NULL,
+	key TEXT NOT NULL,
+	next TEXT NOT NULL,
+	project TEXT NOT NULL,
+	stage TEXT NOT NULL,
+	author TEXT NOT NULL,
+	created_at TIMESTAMP,
+	CONSTRAINT pk_change PRIMARY KEY(sequence) --- an INTEGER primary key numbers rows on SQLite, pgx/000001 adds a sequence
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS grants;
+
+DROP TABLE IF EXISTS principals;
\ No newline at end of file
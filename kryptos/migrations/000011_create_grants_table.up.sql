@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS principals (
+	name TEXT NOT NULL,
+	created_at TIMESTAMP,
+	CONSTRAINT pk_principal PRIMARY KEY(name)
+);
+
+CREATE TABLE IF NOT EXISTS grants (
+	principal TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	project TEXT NOT NULL,
+	pattern TEXT NOT NULL,
+	created_at TIMESTAMP,
+	CONSTRAINT pk_grant PRIMARY KEY(principal, permission, project, pattern)
+);
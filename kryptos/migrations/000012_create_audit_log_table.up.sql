@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	sequence INTEGER NOT NULL,
+	op TEXT NOT NULL,
+	key TEXT NOT NULL,
+	project TEXT NOT NULL,
+	stage TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	host TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP,
+	CONSTRAINT pk_audit_log PRIMARY KEY(sequence) --- numbered like changes, updates and deletes are rejected by triggers per driver
+);
@@ -0,0 +1 @@
+DELETE FROM kryptos_meta WHERE name = 'bind_pending';
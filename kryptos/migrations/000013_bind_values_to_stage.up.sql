@@ -0,0 +1,3 @@
+INSERT INTO kryptos_meta(name, value)
+	VALUES('bind_pending', '3')
+	ON CONFLICT(name) DO UPDATE SET value = excluded.value;
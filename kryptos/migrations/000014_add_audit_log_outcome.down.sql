@@ -0,0 +1,3 @@
+ALTER TABLE audit_log DROP COLUMN error;
+
+ALTER TABLE audit_log DROP COLUMN outcome;
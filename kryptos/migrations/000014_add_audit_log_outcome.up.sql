@@ -0,0 +1,3 @@
+ALTER TABLE audit_log ADD COLUMN outcome TEXT NOT NULL DEFAULT 'ok';
+
+ALTER TABLE audit_log ADD COLUMN error TEXT NOT NULL DEFAULT '';
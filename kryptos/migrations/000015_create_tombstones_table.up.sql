@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS tombstones (
+	uuid TEXT NOT NULL,
+	op TEXT NOT NULL,
+	key TEXT NOT NULL,
+	project TEXT NOT NULL,
+	stage TEXT NOT NULL,
+	version TEXT NOT NULL,
+	CONSTRAINT pk_tombstone PRIMARY KEY(uuid, key, project, stage) --- uuid is a UUIDv7 of when the key was removed or renamed
+);
@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS holds the migrations shared by every driver at its root and the ones written per driver
+// in a directory named after it, they are built into the binary so it runs without the source tree
+//
+//go:embed *.sql sqlite3/*.sql pgx/*.sql
+var FS embed.FS
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS changes_notify ON changes;
+
+DROP FUNCTION IF EXISTS notify_change();
+
+ALTER TABLE changes ALTER COLUMN sequence DROP DEFAULT;
+
+DROP SEQUENCE IF EXISTS changes_sequence;
@@ -0,0 +1,17 @@
+CREATE SEQUENCE IF NOT EXISTS changes_sequence OWNED BY changes.sequence;
+
+SELECT setval('changes_sequence', COALESCE(MAX(sequence), 0) + 1, false) FROM changes;
+
+ALTER TABLE changes ALTER COLUMN sequence SET DEFAULT nextval('changes_sequence');
+
+CREATE OR REPLACE FUNCTION notify_change() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('kryptos_changes', NEW.sequence::text);
+
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS changes_notify ON changes;
+
+CREATE TRIGGER changes_notify AFTER INSERT ON changes FOR EACH ROW EXECUTE FUNCTION notify_change();
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+
+DROP FUNCTION IF EXISTS reject_audit_log_change();
+
+ALTER TABLE audit_log ALTER COLUMN sequence DROP DEFAULT;
+
+DROP SEQUENCE IF EXISTS audit_log_sequence;
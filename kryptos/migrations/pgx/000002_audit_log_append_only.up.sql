@@ -0,0 +1,19 @@
+CREATE SEQUENCE IF NOT EXISTS audit_log_sequence OWNED BY audit_log.sequence;
+
+SELECT setval('audit_log_sequence', COALESCE(MAX(sequence), 0) + 1, false) FROM audit_log;
+
+ALTER TABLE audit_log ALTER COLUMN sequence SET DEFAULT nextval('audit_log_sequence');
+
+CREATE OR REPLACE FUNCTION reject_audit_log_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION reject_audit_log_change();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_log_change();
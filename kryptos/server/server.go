@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"skulpture/kryptos/kryptos"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized = errors.New("missing or invalid bearer token")
+	ErrForbidden    = errors.New("token does not allow this")
+	ErrBadRequest   = errors.New("bad request")
+	ErrTooLarge     = errors.New("request body too large")
+)
+
+// MAX_REQUEST_SIZE bounds the JSON body of a request, values are far smaller
+const MAX_REQUEST_SIZE = 1 << 20
+
+type contextKey string
+
+var contextKeyToken = contextKey("token")
+
+// Server exposes the store as a JSON API under /v1. Every request is authenticated with a bearer token
+// and operates on the project of the token, the encryption key never leaves the server.
+// The project and stage query parameters are accepted on every route
+type Server struct {
+	Db      *sql.DB
+	Driver  string
+	Keyring kryptos.Keyring
+}
+
+type Env struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type EnvsResponse struct {
+	Envs []Env `json:"envs"`
+}
+
+type SetRequest struct {
+	Value    string `json:"value"`
+	IsGlobal bool   `json:"global"`
+}
+
+type DeleteResponse struct {
+	Deleted []string `json:"deleted"`
+}
+
+type MvRequest struct {
+	Previous  string `json:"previous"`
+	Next      string `json:"next"`
+	IsGlobal  bool   `json:"global"`
+	IsProject bool   `json:"project"`
+}
+
+type PruneRequest struct {
+	Offset   int  `json:"offset"`
+	IsGlobal bool `json:"global"`
+	IsAll    bool `json:"all"`
+}
+
+type PruneResponse struct {
+	Pruned  int      `json:"pruned"`
+	Deleted []string `json:"deleted"`
+}
+
+type Stat struct {
+	Key     string `json:"key"`
+	Project string `json:"project"`
+	Stage   string `json:"stage"`
+	Count   int    `json:"count"`
+}
+
+type StatsResponse struct {
+	Stats []Stat `json:"stats"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/envs", server.authenticated("cat", server.list))
+	mux.HandleFunc("GET /v1/envs/{key}", server.authenticated("grep", server.get))
+	mux.HandleFunc("PUT /v1/envs/{key}", server.authenticated("set", server.set))
+	mux.HandleFunc("DELETE /v1/envs/{key}", server.authenticated("rm", server.rm))
+	mux.HandleFunc("POST /v1/mv", server.authenticated("mv", server.mv))
+	mux.HandleFunc("POST /v1/prune", server.authenticated("prune", server.prune))
+	mux.HandleFunc("GET /v1/stats", server.authenticated("stat", server.stats))
+
+	return mux
+}
+
+// authenticated runs the handler for a valid token and records op in the audit log against the token,
+// as started before the handler runs and with its outcome after. A request that cannot be recorded is not handled
+func (server *Server) authenticated(op string, handler func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		isDebugEnabled, _ := r.Context().Value(kryptos.ContextKeyDebug).(bool)
+
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, ErrUnauthorized)
+
+			return
+		}
+
+		token, err := kryptos.Authenticate(r.Context(), server.Db, bearer)
+		if errors.Is(err, kryptos.ErrInvalidToken) {
+			writeError(w, ErrUnauthorized)
+
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyToken, token))
+
+		err = server.audit(r, op, kryptos.AUDIT_OUTCOME_STARTED, nil)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		project := r.URL.Query().Get("project")
+		if project != "" && project != token.Project && token.Project != "*" {
+			err = fmt.Errorf("%w: token is for %s", ErrForbidden, token.Project)
+		} else {
+			err = handler(w, r)
+		}
+		if err != nil {
+			writeError(w, err)
+		}
+
+		// the response has been written, a failure to record it can only be logged
+		auditErr := server.audit(r, op, kryptos.AuditOutcome(err), err)
+		if auditErr != nil {
+			slog.ErrorContext(r.Context(), "audit", "method", r.Method, "path", r.URL.Path, "token", token.Name, "error", auditErr)
+		}
+
+		if isDebugEnabled {
+			slog.InfoContext(r.Context(), "serve", "method", r.Method, "path", r.URL.Path, "project", token.Project, "token", token.Name, "error", err)
+		}
+	}
+}
+
+// audit records op against the token of the request, a forbidden request is denied
+func (server *Server) audit(r *http.Request, op string, outcome string, err error) error {
+	token := r.Context().Value(contextKeyToken).(kryptos.Token)
+	store := server.store(r)
+
+	entry := kryptos.AuditEntry{
+		Op:      op,
+		Key:     r.PathValue("key"),
+		Project: store.Project(),
+		Stage:   store.Stage(),
+		Actor:   token.Name,
+		Host:    r.RemoteAddr,
+		Reason:  r.Header.Get(kryptos.REASON_HEADER),
+		Outcome: outcome,
+	}
+	if errors.Is(err, ErrForbidden) {
+		entry.Outcome = kryptos.AUDIT_OUTCOME_DENIED
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return kryptos.RecordAudit(r.Context(), server.Db, entry)
+}
+
+// store is scoped to the project of the token and the stage in the query, a token for * can name any project
+func (server *Server) store(r *http.Request) *kryptos.Store {
+	token := r.Context().Value(contextKeyToken).(kryptos.Token)
+
+	project := token.Project
+	if token.Project == "*" && r.URL.Query().Get("project") != "" {
+		project = r.URL.Query().Get("project")
+	}
+
+	return kryptos.NewStore(
+		kryptos.WithDb(server.Db),
+		kryptos.WithDriver(server.Driver),
+		kryptos.WithProject(project),
+		kryptos.WithStage(r.URL.Query().Get("stage")),
+		kryptos.WithAuthor(token.Name),
+		kryptos.WithKeyring(server.Keyring),
+	)
+}
+
+// authorize rejects writes with a read-only token, global values and projects can only be changed with a token for *
+func authorize(r *http.Request, isGlobal bool) error {
+	token := r.Context().Value(contextKeyToken).(kryptos.Token)
+
+	if token.IsReadOnly {
+		return ErrForbidden
+	}
+
+	if isGlobal && token.Project != "*" {
+		return fmt.Errorf("%w: global values need a token for *", ErrForbidden)
+	}
+
+	return nil
+}
+
+func (server *Server) list(w http.ResponseWriter, r *http.Request) error {
+	store := server.store(r)
+
+	asOf := time.Time{}
+	if r.URL.Query().Has("as_of") {
+		var err error
+		asOf, err = time.Parse(time.RFC3339, r.URL.Query().Get("as_of"))
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrBadRequest, err)
+		}
+	}
+
+	envs, err := store.ListAsOf(r.Context(), asOf)
+	if err != nil {
+		return err
+	}
+
+	response := EnvsResponse{
+		Envs: []Env{},
+	}
+	for key, value := range envs.Iterator() {
+		response.Envs = append(response.Envs, Env{Key: key, Value: value})
+	}
+
+	return writeJson(w, http.StatusOK, response)
+}
+
+func (server *Server) get(w http.ResponseWriter, r *http.Request) error {
+	key := r.PathValue("key")
+
+	value, ok, err := server.store(r).Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: %s", kryptos.ErrKeyNotFound, key)
+	}
+
+	return writeJson(w, http.StatusOK, Env{Key: key, Value: value})
+}
+
+func (server *Server) set(w http.ResponseWriter, r *http.Request) error {
+	request := SetRequest{}
+	err := readJson(w, r, &request)
+	if err != nil {
+		return err
+	}
+
+	err = authorize(r, request.IsGlobal)
+	if err != nil {
+		return err
+	}
+
+	err = server.store(r).Set(r.Context(), r.PathValue("key"), request.Value, request.IsGlobal)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (server *Server) rm(w http.ResponseWriter, r *http.Request) error {
+	includeDeprecated, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	includeGlobal, _ := strconv.ParseBool(r.URL.Query().Get("global"))
+
+	err := authorize(r, includeGlobal)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := server.store(r).Delete(r.Context(), r.PathValue("key"), includeDeprecated, includeGlobal)
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, http.StatusOK, DeleteResponse{Deleted: deleted})
+}
+
+func (server *Server) mv(w http.ResponseWriter, r *http.Request) error {
+	request := MvRequest{}
+	err := readJson(w, r, &request)
+	if err != nil {
+		return err
+	}
+
+	err = authorize(r, request.IsGlobal || request.IsProject)
+	if err != nil {
+		return err
+	}
+
+	err = server.store(r).Rename(r.Context(), request.Previous, request.Next, request.IsGlobal, request.IsProject)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (server *Server) prune(w http.ResponseWriter, r *http.Request) error {
+	request := PruneRequest{}
+	err := readJson(w, r, &request)
+	if err != nil {
+		return err
+	}
+
+	err = authorize(r, request.IsGlobal)
+	if err != nil {
+		return err
+	}
+
+	store := server.store(r)
+	response := PruneResponse{
+		Deleted: []string{},
+	}
+
+	if request.IsAll {
+		response.Deleted, err = store.Clear(r.Context(), request.Offset, request.IsGlobal)
+		response.Pruned = len(response.Deleted)
+	} else {
+		response.Pruned, err = store.Prune(r.Context(), request.Offset, request.IsGlobal)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, http.StatusOK, response)
+}
+
+func (server *Server) stats(w http.ResponseWriter, r *http.Request) error {
+	envStats, err := server.store(r).Stats(r.Context())
+	if err != nil {
+		return err
+	}
+
+	response := StatsResponse{
+		Stats: []Stat{},
+	}
+	for _, envStat := range envStats {
+		response.Stats = append(response.Stats, Stat{
+			Key:     envStat.Key,
+			Project: envStat.Project,
+			Stage:   envStat.Stage,
+			Count:   envStat.Count,
+		})
+	}
+
+	return writeJson(w, http.StatusOK, response)
+}
+
+func readJson(w http.ResponseWriter, r *http.Request, out any) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE)).Decode(out)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("%w: over %d bytes", ErrTooLarge, maxBytesError.Limit)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadRequest, err)
+	}
+
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(body)
+}
+
+// writeError answers with the status of err, internal errors are logged and answered with a generic message
+// so database and key errors are not disclosed to the client
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, kryptos.ErrKeyNotFound), errors.Is(err, kryptos.ErrVersionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, kryptos.ErrHistoryUnavailable):
+		status = http.StatusConflict
+	}
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		slog.Error("serve", "error", err)
+		message = http.StatusText(status)
+	}
+
+	writeJson(w, status, ErrorResponse{Error: message})
+}
@@ -0,0 +1,274 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"skulpture/kryptos/kryptos"
+	"skulpture/kryptos/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) (*httptest.Server, map[string]string, *sql.DB) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	t.Cleanup(kryptos.ResetOverrides)
+
+	t.Setenv("PROJECT", "test")
+	t.Setenv("DB_DRIVER", "sqlite3")
+	t.Setenv("AUTHOR", "test")
+	t.Setenv("DB_CONNECTION_STRING", "file:server.db?mode=memory")
+
+	encryptionKey := make([]byte, 32)
+	rand.Read(encryptionKey)
+	t.Setenv("ENCRYPTION_KEY", hex.EncodeToString(encryptionKey))
+
+	db, close, err := kryptos.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close()
+	})
+
+	// the in-memory database only exists on the connection that migrated it
+	db.SetMaxOpenConns(1)
+
+	tokens := map[string]string{}
+	scopes := []struct {
+		name       string
+		project    string
+		isReadOnly bool
+	}{
+		{"writer", "api", false},
+		{"reader", "api", true},
+		{"other", "web", false},
+		{"admin", "*", false},
+	}
+
+	for _, scope := range scopes {
+		tokens[scope.name], err = kryptos.CreateToken(ctx, db, scope.project, scope.name, scope.isReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	api := server.Server{
+		Db:      db,
+		Driver:  "sqlite3",
+		Keyring: kryptos.CurrentKeyring(),
+	}
+
+	httpServer := httptest.NewServer(api.Handler())
+	t.Cleanup(httpServer.Close)
+
+	return httpServer, tokens, db
+}
+
+func request(t *testing.T, httpServer *httptest.Server, token string, method string, path string, body any, out any) int {
+	encoded := []byte{}
+	if body != nil {
+		var err error
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, httpServer.URL+path, bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set(kryptos.REASON_HEADER, "test")
+
+	res, err := httpServer.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(res.Body).Decode(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+func TestServerAuthentication(t *testing.T) {
+	httpServer, tokens, _ := newServer(t)
+
+	assert.Equal(t, http.StatusUnauthorized, request(t, httpServer, "", "GET", "/v1/envs", nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, request(t, httpServer, "kryptos_invalid", "GET", "/v1/envs", nil, nil))
+	assert.Equal(t, http.StatusOK, request(t, httpServer, tokens["reader"], "GET", "/v1/envs", nil, nil))
+}
+
+func TestServerSetGet(t *testing.T) {
+	httpServer, tokens, _ := newServer(t)
+
+	status := request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "api"}, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status = request(t, httpServer, tokens["admin"], "PUT", "/v1/envs/SERVER2", server.SetRequest{Value: "global", IsGlobal: true}, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	env := server.Env{}
+	status = request(t, httpServer, tokens["reader"], "GET", "/v1/envs/SERVER1", nil, &env)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "api", env.Value)
+
+	envs := server.EnvsResponse{}
+	status = request(t, httpServer, tokens["reader"], "GET", "/v1/envs", nil, &envs)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []server.Env{{Key: "SERVER1", Value: "api"}, {Key: "SERVER2", Value: "global"}}, envs.Envs)
+
+	status = request(t, httpServer, tokens["other"], "GET", "/v1/envs/SERVER1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status = request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "api"}, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	stats := server.StatsResponse{}
+	status = request(t, httpServer, tokens["reader"], "GET", "/v1/stats", nil, &stats)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []server.Stat{{Key: "SERVER1", Project: "api", Count: 2}, {Key: "SERVER2", Project: "*", Count: 1}}, stats.Stats)
+}
+
+func TestServerForbidden(t *testing.T) {
+	httpServer, tokens, _ := newServer(t)
+
+	status := request(t, httpServer, tokens["reader"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "api"}, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status = request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "api", IsGlobal: true}, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status = request(t, httpServer, tokens["writer"], "POST", "/v1/mv", server.MvRequest{Previous: "api", Next: "web", IsProject: true}, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status = request(t, httpServer, tokens["reader"], "DELETE", "/v1/envs/SERVER1", nil, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status = request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: strings.Repeat("a", server.MAX_REQUEST_SIZE)}, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	status = request(t, httpServer, tokens["writer"], "GET", "/v1/envs/SERVER1", nil, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServerMvRmPrune(t *testing.T) {
+	httpServer, tokens, _ := newServer(t)
+
+	for _, value := range []string{"1", "2", "3"} {
+		status := request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: value}, nil)
+		assert.Equal(t, http.StatusNoContent, status)
+	}
+
+	status := request(t, httpServer, tokens["writer"], "POST", "/v1/mv", server.MvRequest{Previous: "SERVER1", Next: "SERVER3"}, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	env := server.Env{}
+	status = request(t, httpServer, tokens["writer"], "GET", "/v1/envs/SERVER3", nil, &env)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "3", env.Value)
+
+	pruned := server.PruneResponse{}
+	status = request(t, httpServer, tokens["writer"], "POST", "/v1/prune", server.PruneRequest{Offset: 1}, &pruned)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, pruned.Pruned)
+
+	deleted := server.DeleteResponse{}
+	status = request(t, httpServer, tokens["writer"], "DELETE", "/v1/envs/SERVER3", nil, &deleted)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"SERVER3"}, deleted.Deleted)
+
+	status = request(t, httpServer, tokens["writer"], "GET", "/v1/envs/SERVER3", nil, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServerAudit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	httpServer, tokens, db := newServer(t)
+
+	status := request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "1"}, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status = request(t, httpServer, tokens["reader"], "GET", "/v1/envs/SERVER1", nil, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	status = request(t, httpServer, tokens["reader"], "PUT", "/v1/envs/SERVER1", server.SetRequest{Value: "2"}, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// unauthenticated requests are not recorded
+	status = request(t, httpServer, "", "GET", "/v1/envs", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	entries, err := kryptos.AuditLog(ctx, db, kryptos.AuditFilter{Project: "*", Key: "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every request is recorded as started before it is handled, then with its outcome
+	if assert.Len(t, entries, 6) {
+		assert.Equal(t, "set", entries[0].Op)
+		assert.Equal(t, "writer", entries[0].Actor)
+		assert.Equal(t, kryptos.AUDIT_OUTCOME_STARTED, entries[0].Outcome)
+		assert.Equal(t, kryptos.AUDIT_OUTCOME_OK, entries[1].Outcome)
+		assert.Equal(t, "grep", entries[3].Op)
+		assert.Equal(t, "SERVER1", entries[3].Key)
+		assert.Equal(t, "api", entries[3].Project)
+		assert.Equal(t, "reader", entries[3].Actor)
+		assert.Equal(t, "test", entries[3].Reason)
+		assert.Equal(t, "set", entries[5].Op)
+		assert.Equal(t, kryptos.AUDIT_OUTCOME_DENIED, entries[5].Outcome)
+		assert.NotEmpty(t, entries[5].Error)
+	}
+
+	// a request that cannot be recorded is not handled
+	_, err = db.ExecContext(ctx, "DROP TABLE audit_log;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status = request(t, httpServer, tokens["writer"], "PUT", "/v1/envs/SERVER2", server.SetRequest{Value: "2"}, nil)
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	count := 0
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM environments WHERE key = 'SERVER2';").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, count)
+}
+
+func TestServerInternalError(t *testing.T) {
+	ctx := context.WithValue(context.Background(), kryptos.ContextKeyDebug, false)
+
+	httpServer, tokens, db := newServer(t)
+
+	_, err := db.ExecContext(ctx, "DROP TABLE environments;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// database errors are logged by the server and not sent to the client
+	response := server.ErrorResponse{}
+	status := request(t, httpServer, tokens["writer"], "GET", "/v1/envs/SERVER1", nil, &response)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), response.Error)
+}